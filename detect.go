@@ -0,0 +1,328 @@
+package jsonredact
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Detector finds sensitive data in string values regardless of their paths, see Rule.Detector.
+type Detector interface {
+	// Detect returns byte ranges [start, end) of sensitive substrings of s in order, nil if there are none.
+	Detect(s string) [][2]int
+}
+
+// DetectorFunc is a function implementing Detector.
+type DetectorFunc func(s string) [][2]int
+
+func (f DetectorFunc) Detect(s string) [][2]int {
+	return f(s)
+}
+
+// DetectRegexp detects substrings matching re.
+func DetectRegexp(re *regexp.Regexp) Detector {
+	return regexDetector{re: re}
+}
+
+// DetectEmails detects email addresses.
+func DetectEmails() Detector {
+	return emailDetector
+}
+
+// DetectCards detects payment card numbers of 13 to 19 digits, optionally grouped by spaces or dashes,
+// passing the Luhn check.
+func DetectCards() Detector {
+	return cardDetector
+}
+
+// DetectIBANs detects IBANs, optionally grouped by spaces, passing the mod 97 check.
+func DetectIBANs() Detector {
+	return ibanDetector
+}
+
+// DetectPhones detects phone numbers in international format, e.g. +1 415 555 2671, or with an area code in
+// parentheses, e.g. (415) 555-2671.
+func DetectPhones() Detector {
+	return phoneDetector
+}
+
+// DetectJWTs detects JSON Web Tokens.
+func DetectJWTs() Detector {
+	return jwtDetector
+}
+
+// DetectAWSKeys detects AWS access key IDs.
+func DetectAWSKeys() Detector {
+	return awsKeyDetector
+}
+
+// DetectAll detects anything any of detectors does.
+func DetectAll(detectors ...Detector) Detector {
+	return DetectorFunc(func(s string) [][2]int {
+		var found [][2]int
+		for _, d := range detectors {
+			found = appendRanges(found, d.Detect(s))
+		}
+		return found
+	})
+}
+
+// appendRanges merges sorted ranges b into sorted ranges a joining overlapping ones.
+func appendRanges(a, b [][2]int) [][2]int {
+	if len(b) == 0 {
+		return a
+	}
+	merged := make([][2]int, 0, len(a)+len(b))
+	for len(a) != 0 || len(b) != 0 {
+		var next [2]int
+		if len(b) == 0 || len(a) != 0 && a[0][0] <= b[0][0] {
+			next, a = a[0], a[1:]
+		} else {
+			next, b = b[0], b[1:]
+		}
+		if last := len(merged) - 1; last >= 0 && next[0] <= merged[last][1] {
+			merged[last][1] = max(merged[last][1], next[1])
+			continue
+		}
+		merged = append(merged, next)
+	}
+	return merged
+}
+
+var (
+	emailDetector = regexDetector{
+		re:    regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+		quick: func(s string) bool { return strings.IndexByte(s, '@') >= 0 },
+	}
+	cardDetector = regexDetector{
+		// runs of digit groups, cards are looked up in them by cardsIn
+		re:    regexp.MustCompile(`\b\d(?:[ \-]?\d){12,}\b`),
+		quick: hasDigits(13),
+		find:  cardsIn,
+	}
+	ibanDetector = regexDetector{
+		// runs of groups, IBANs are looked up in them by ibansIn
+		re:    regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`),
+		quick: hasDigits(2),
+		find:  ibansIn,
+	}
+	phoneDetector = regexDetector{
+		re:    regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?(?:\(\d{1,4}\)[ .\-]?)?|\(\d{2,4}\)[ .\-]?)\d{2,4}(?:[ .\-]?\d{2,4}){1,4}\b`),
+		quick: hasDigits(7),
+		valid: func(s string) bool { n := len(digits(s)); return n >= 7 && n <= 15 },
+	}
+	jwtDetector = regexDetector{
+		re:    regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`),
+		quick: func(s string) bool { return strings.Contains(s, "eyJ") },
+	}
+	awsKeyDetector = regexDetector{
+		re: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA|AIDA|AROA|AIPA|ANPA|ANVA|APKA)[A-Z0-9]{16}\b`),
+	}
+)
+
+// regexDetector detects matches of re passing valid, quick rules out strings without matches cheaply.
+// find, if set, replaces valid and returns ranges of sensitive data inside a match.
+type regexDetector struct {
+	re    *regexp.Regexp
+	quick func(s string) bool
+	valid func(match string) bool
+	find  func(match string) [][2]int
+}
+
+func (d regexDetector) Detect(s string) [][2]int {
+	if d.quick != nil && !d.quick(s) {
+		return nil
+	}
+	found := d.re.FindAllStringIndex(s, -1)
+	if d.valid == nil && d.find == nil {
+		return toRanges(found)
+	}
+	var valid [][2]int
+	for _, m := range found {
+		if d.find != nil {
+			for _, r := range d.find(s[m[0]:m[1]]) {
+				valid = append(valid, [2]int{m[0] + r[0], m[0] + r[1]})
+			}
+		} else if d.valid(s[m[0]:m[1]]) {
+			valid = append(valid, [2]int{m[0], m[1]})
+		}
+	}
+	return valid
+}
+
+func toRanges(found [][]int) [][2]int {
+	if found == nil {
+		return nil
+	}
+	ranges := make([][2]int, len(found))
+	for i, m := range found {
+		ranges[i] = [2]int{m[0], m[1]}
+	}
+	return ranges
+}
+
+func hasDigits(n int) func(string) bool {
+	return func(s string) bool {
+		left := n
+		for i := 0; i < len(s) && left > 0; i++ {
+			if '0' <= s[i] && s[i] <= '9' {
+				left--
+			}
+		}
+		return left == 0
+	}
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if '0' <= r && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// groupsOf returns ranges of groups of run separated by any of the bytes of separators.
+func groupsOf(run, separators string) [][2]int {
+	var groups [][2]int
+	start := 0
+	for i := 0; i <= len(run); i++ {
+		if i == len(run) || strings.IndexByte(separators, run[i]) >= 0 {
+			if i > start {
+				groups = append(groups, [2]int{start, i})
+			}
+			start = i + 1
+		}
+	}
+	return groups
+}
+
+// cardsIn returns ranges of card numbers in a run of digit groups separated by spaces or dashes.
+// A card is the longest sequence of whole groups of 13 to 19 digits passing the Luhn check,
+// so digits next to a card, e.g. an amount, don't hide it.
+func cardsIn(run string) [][2]int {
+	groups := groupsOf(run, " -")
+	counts := make([]int, len(groups)+1) // counts[k] is the number of digits in groups[:k]
+	for k, g := range groups {
+		counts[k+1] = counts[k] + g[1] - g[0]
+	}
+	var cards [][2]int
+	for i := 0; i < len(groups); i++ {
+		last := i
+		for last+1 < len(groups) && counts[last+2]-counts[i] <= 19 {
+			last++
+		}
+		for j := last; j >= i && counts[j+1]-counts[i] >= 13; j-- {
+			card := run[groups[i][0]:groups[j][1]]
+			if counts[j+1]-counts[i] <= 19 && luhn(digits(card)) {
+				cards = append(cards, [2]int{groups[i][0], groups[j][1]})
+				i = j
+				break
+			}
+		}
+	}
+	return cards
+}
+
+// ibansIn returns ranges of IBANs in a run of groups separated by spaces.
+// An IBAN is the longest sequence of whole groups starting by a country code and check digits and passing
+// the mod 97 check, so words next to an IBAN, e.g. a currency, don't hide it.
+func ibansIn(run string) [][2]int {
+	groups := groupsOf(run, " ")
+	var ibans [][2]int
+	for i := 0; i < len(groups); i++ {
+		if !ibanStart(run[groups[i][0]:groups[i][1]]) {
+			continue
+		}
+		for j := len(groups) - 1; j >= i; j-- {
+			if validIBAN(run[groups[i][0]:groups[j][1]]) {
+				ibans = append(ibans, [2]int{groups[i][0], groups[j][1]})
+				i = j
+				break
+			}
+		}
+	}
+	return ibans
+}
+
+// ibanStart reports whether group starts by a country code and check digits.
+func ibanStart(group string) bool {
+	return len(group) >= 4 && 'A' <= group[0] && group[0] <= 'Z' && 'A' <= group[1] && group[1] <= 'Z' &&
+		'0' <= group[2] && group[2] <= '9' && '0' <= group[3] && group[3] <= '9'
+}
+
+func luhn(number string) bool {
+	sum := 0
+	for i := range number {
+		d := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return len(number) != 0 && sum%10 == 0
+}
+
+// validIBAN checks length and the mod 97 checksum of the IBAN with the country code and check digits moved to the end.
+func validIBAN(iban string) bool {
+	iban = strings.ReplaceAll(iban, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	remainder := 0
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case 'A' <= c && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
+
+// normalizeRanges clamps ranges of a user Detector to [0, n), drops empty ones, sorts and merges them,
+// so they can slice a string of length n in order.
+func normalizeRanges(ranges [][2]int, n int) [][2]int {
+	valid := make([][2]int, 0, len(ranges))
+	for _, r := range ranges {
+		r = [2]int{min(max(r[0], 0), n), min(max(r[1], 0), n)}
+		if r[0] < r[1] {
+			valid = append(valid, r)
+		}
+	}
+	slices.SortFunc(valid, func(a, b [2]int) int { return cmp.Compare(a[0], b[0]) })
+	return appendRanges(nil, valid)
+}
+
+// partialHandler replaces only the found substrings of a string value by replacements of handler.
+type partialHandler struct {
+	handler Handler
+	found   [][2]int
+}
+
+func (h partialHandler) appendReplacement(dst []byte, v Value) []byte {
+	text := make([]byte, 0, len(v.Str))
+	last := 0
+	for _, r := range normalizeRanges(h.found, len(v.Str)) {
+		substring := v.Str[r[0]:r[1]]
+		raw := string(appendJSONString(nil, substring))
+		replacement := gjson.ParseBytes(h.handler.appendReplacement(nil, Value{Type: TypeString, Raw: raw, Str: substring, Path: v.Path}))
+		text = append(text, v.Str[last:r[0]]...)
+		if replacement.Type == gjson.String {
+			text = append(text, replacement.Str...)
+		} else {
+			text = append(text, replacement.Raw...)
+		}
+		last = r[1]
+	}
+	text = append(text, v.Str[last:]...)
+	return appendJSONString(dst, unsafeString(text))
+}
@@ -1,41 +1,355 @@
 package jsonredact
 
-import "strings"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
 
 type expression string
 
-func (e expression) splitByPoint() []string {
-	var elems []string
+type segmentKind uint8
+
+const (
+	keySegment       segmentKind = iota // exact key or array index
+	anySegment                          // '#'
+	recursiveSegment                    // '*'
+	globSegment                         // key with '*' matching any characters, e.g. *pass*
+	regexSegment                        // key matching a regex, e.g. /(?i)^pass/
+	indexSegment                        // array indexes, e.g. [0:3], [-1] or [1,4,7]
+	filterSegment                       // filter of the current object not consuming a key, e.g. [type=="password_reset"]
+)
+
+type segment struct {
+	kind    segmentKind
+	key     string
+	glob    []string       // literal parts between '*' of a glob
+	re      *regexp.Regexp // regex of a regex segment
+	indexes []indexRange   // ranges of an index segment
+	fold    bool           // (?i): match keys case-insensitively
+	guards  []*predicate   // filters of the matched value and the predicate after the last segment
+	column  int            // 1-based position of the segment in its expression, for error reporting
+}
+
+// ExpressionError describes a malformed expression.
+type ExpressionError struct {
+	Index      int    // position of the expression in the list given to the constructor
+	Expression string // the expression itself
+	Column     int    // 1-based position of the offending character
+	Reason     string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("jsonredact: expression %d %q: column %d: %s", e.Index, e.Expression, e.Column, e.Reason)
+}
+
+func (e expression) parse() ([]segment, error) {
+	runes := []rune(e)
+	if len(runes) == 0 {
+		return nil, &ExpressionError{Expression: string(e), Column: 1, Reason: "empty expression"}
+	}
+	var segments []segment
 	builder := strings.Builder{}
-	wasEscape := false
-	for _, c := range []rune(e) {
-		switch c {
-		case '#', '*':
-			if wasEscape {
-				wasEscape = false
-				if len(e) == 2 { //just \*
-					_, _ = builder.WriteString(`\`)
+	escaped := false
+	var glob []string // parts of the segment before every unescaped '*'
+	partStart := 0    // start of the current glob part in builder
+	fold := false
+	start, keyStart := 0, 0 // start of the segment and of its key after (?i)
+	var parsed *segment     // regex, index or filter segment, only filters and a predicate may follow it in its segment
+	var filters []*predicate
+	current := func() segment {
+		var seg segment
+		if parsed != nil {
+			seg = *parsed
+		} else {
+			key := builder.String()
+			if glob != nil {
+				glob = append(glob, key[partStart:])
+			}
+			seg = newSegment(key, escaped, glob, start+1)
+		}
+		seg.fold, seg.column = fold, start+1
+		seg.guards = append(seg.guards, filters...)
+		return seg
+	}
+	for i := 0; i < len(runes); i++ {
+		if i == start && strings.HasPrefix(string(runes[i:min(i+4, len(runes))]), "(?i)") {
+			fold, keyStart = true, i+4
+			i += 3
+			continue
+		}
+		switch c := runes[i]; c {
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "dangling escape"}
+			}
+			i++
+			_, _ = builder.WriteRune(runes[i])
+			escaped = true
+		case '.':
+			if builder.Len() == 0 && parsed == nil {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "empty segment"}
+			}
+			segments = append(segments, current())
+			builder.Reset()
+			escaped, glob, partStart, fold, parsed, filters = false, nil, 0, false, nil, nil
+			start, keyStart = i+1, i+1
+		case '?':
+			if i+1 == len(runes) || runes[i+1] != '(' {
+				_, _ = builder.WriteRune(c)
+				continue
+			}
+			if builder.Len() == 0 && parsed == nil {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "empty segment"}
+			}
+			if runes[len(runes)-1] != ')' {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "predicate must end the expression with ')'"}
+			}
+			p, err := parsePredicate(string(runes[i+2 : len(runes)-1]))
+			if err != nil {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "invalid predicate: " + err.Error()}
+			}
+			seg := current()
+			seg.guards = append(seg.guards, p)
+			segments = append(segments, seg)
+			return segments, e.validate(segments)
+		case '*':
+			glob = append(glob, builder.String()[partStart:])
+			_ = builder.WriteByte('*')
+			partStart = builder.Len()
+		case '/':
+			if i != keyStart {
+				_, _ = builder.WriteRune(c)
+				continue
+			}
+			seg, end, err := e.parseRegex(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			if fold {
+				if seg, err = seg.folded(); err != nil {
+					return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "invalid regex: " + err.Error()}
+				}
+			}
+			parsed, i = &seg, end
+		case '[':
+			end := closingBracket(runes, i)
+			if end < 0 {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "unterminated '['"}
+			}
+			body := string(runes[i+1 : end])
+			if i == keyStart && indexUnquoted(body, "==") < 0 && indexUnquoted(body, "!=") < 0 {
+				seg, end, err := e.parseIndexes(runes, i)
+				if err != nil {
+					return nil, err
 				}
+				parsed, i = &seg, end
+				continue
 			}
+			f, err := parseFilter(body)
+			if err != nil {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "invalid filter: " + err.Error()}
+			}
+			if !endsSegment(runes, end+1) {
+				return nil, &ExpressionError{Expression: string(e), Column: end + 2, Reason: "filter must end its segment"}
+			}
+			if i == keyStart {
+				parsed = &segment{kind: filterSegment}
+			}
+			filters, i = append(filters, f), end
+		default:
 			_, _ = builder.WriteRune(c)
+		}
+	}
+	if builder.Len() == 0 && parsed == nil {
+		return nil, &ExpressionError{Expression: string(e), Column: len(runes), Reason: "empty segment"}
+	}
+	segments = append(segments, current())
+	return segments, e.validate(segments)
+}
+
+// parseLiteral parses e the way expressions were parsed before globs, regexes, selectors, filters and predicates:
+// segments other than '#' and '*' are literal keys, so keys like /api/v1 or items[0] configured then keep matching.
+func (e expression) parseLiteral() ([]segment, error) {
+	runes := []rune(e)
+	if len(runes) == 0 {
+		return nil, &ExpressionError{Expression: string(e), Column: 1, Reason: "empty expression"}
+	}
+	var segments []segment
+	builder := strings.Builder{}
+	escaped := false
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
 		case '\\':
-			if wasEscape {
-				wasEscape = false
-				_, _ = builder.WriteString(`\`)
-			} else {
-				wasEscape = true
+			if i+1 == len(runes) {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "dangling escape"}
 			}
+			i++
+			_, _ = builder.WriteRune(runes[i])
+			escaped = true
 		case '.':
-			if wasEscape {
-				_, _ = builder.WriteRune(c)
-				wasEscape = false
-			} else {
-				elems = append(elems, builder.String())
-				builder.Reset()
+			if builder.Len() == 0 {
+				return nil, &ExpressionError{Expression: string(e), Column: i + 1, Reason: "empty segment"}
+			}
+			segments = append(segments, newSegment(builder.String(), escaped, nil, start+1))
+			builder.Reset()
+			escaped, start = false, i+1
+		default:
+			_, _ = builder.WriteRune(c)
+		}
+	}
+	if builder.Len() == 0 {
+		return nil, &ExpressionError{Expression: string(e), Column: len(runes), Reason: "empty segment"}
+	}
+	segments = append(segments, newSegment(builder.String(), escaped, nil, start+1))
+	return segments, e.validate(segments)
+}
+
+// parseRegex parses a regex segment starting with '/' at runes[start] and returns it with the index of the closing '/'.
+// Inside the regex `\/` stands for '/', other escapes are passed to the regex as is.
+func (e expression) parseRegex(runes []rune, start int) (segment, int, error) {
+	builder := strings.Builder{}
+	for i := start + 1; i < len(runes); i++ {
+		switch c := runes[i]; {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			if runes[i] != '/' {
+				_ = builder.WriteByte('\\')
+			}
+			_, _ = builder.WriteRune(runes[i])
+		case c == '/':
+			if !endsSegment(runes, i+1) {
+				return segment{}, 0, &ExpressionError{Expression: string(e), Column: i + 2, Reason: "regex must end its segment"}
 			}
+			re, err := regexp.Compile(builder.String())
+			if err != nil {
+				return segment{}, 0, &ExpressionError{Expression: string(e), Column: start + 1, Reason: "invalid regex: " + err.Error()}
+			}
+			return segment{kind: regexSegment, key: builder.String(), re: re, column: start + 1}, i, nil
 		default:
 			_, _ = builder.WriteRune(c)
 		}
 	}
-	return append(elems, builder.String())
+	return segment{}, 0, &ExpressionError{Expression: string(e), Column: start + 1, Reason: "unterminated regex"}
+}
+
+// parseIndexes parses an index segment starting with '[' at runes[start] and returns it with the index of the closing ']'.
+// Items are separated by ',', an item is an index or a slice from:to with optional bounds, negative ones count from the end.
+func (e expression) parseIndexes(runes []rune, start int) (segment, int, error) {
+	end := closingBracket(runes, start)
+	if !endsSegment(runes, end+1) {
+		return segment{}, 0, &ExpressionError{Expression: string(e), Column: end + 2, Reason: "index selector must end its segment"}
+	}
+	var ranges []indexRange
+	column := start + 2
+	for _, item := range strings.Split(string(runes[start+1:end]), ",") {
+		r, ok := parseIndexRange(item)
+		if !ok {
+			return segment{}, 0, &ExpressionError{Expression: string(e), Column: column, Reason: fmt.Sprintf("invalid index %q", item)}
+		}
+		ranges = append(ranges, r)
+		column += len([]rune(item)) + 1
+	}
+	return segment{kind: indexSegment, key: string(runes[start : end+1]), indexes: ranges, column: start + 1}, end, nil
+}
+
+func parseIndexRange(item string) (indexRange, bool) {
+	from, to, isSlice := strings.Cut(item, ":")
+	if !isSlice {
+		i, err := strconv.Atoi(item)
+		if err != nil {
+			return indexRange{}, false
+		}
+		return indexRange{from: i, to: i + 1, toEnd: i == -1}, true
+	}
+	r := indexRange{toEnd: to == ""}
+	var err error
+	if from != "" {
+		if r.from, err = strconv.Atoi(from); err != nil {
+			return indexRange{}, false
+		}
+	}
+	if to != "" {
+		if r.to, err = strconv.Atoi(to); err != nil {
+			return indexRange{}, false
+		}
+	}
+	return r, true
+}
+
+// endsSegment reports whether runes[i] ends the key of a segment: the end of the expression, '.', a filter or a predicate.
+func endsSegment(runes []rune, i int) bool {
+	return i == len(runes) || runes[i] == '.' || runes[i] == '[' || runes[i] == '?' && i+1 < len(runes) && runes[i+1] == '('
+}
+
+// closingBracket returns the index of ']' closing '[' at runes[start] skipping JSON strings, -1 if there is none.
+func closingBracket(runes []rune, start int) int {
+	for i, inString, escaped := start+1, false, false; i < len(runes); i++ {
+		switch c := runes[i]; {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case !inString && c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func (e expression) validate(segments []segment) error {
+	keys := 0
+	for i, s := range segments {
+		if s.kind != filterSegment {
+			keys++
+		}
+		if s.kind != recursiveSegment {
+			continue
+		}
+		if i == len(segments)-1 {
+			return &ExpressionError{Expression: string(e), Column: s.column, Reason: "'*' must be followed by a segment"}
+		}
+		if segments[i+1].kind == recursiveSegment {
+			return &ExpressionError{Expression: string(e), Column: segments[i+1].column, Reason: "'*' must not be followed by '*'"}
+		}
+		if segments[i+1].kind == filterSegment {
+			return &ExpressionError{Expression: string(e), Column: segments[i+1].column, Reason: "'*' must not be followed by a filter"}
+		}
+	}
+	if keys == 0 {
+		return &ExpressionError{Expression: string(e), Column: 1, Reason: "expression has only filters"}
+	}
+	return nil
+}
+
+// folded returns the segment matching keys case-insensitively, a regex is compiled with (?i) from its source.
+func (s segment) folded() (segment, error) {
+	s.fold = true
+	if s.kind == regexSegment {
+		re, err := regexp.Compile("(?i)" + s.re.String())
+		if err != nil {
+			return segment{}, err
+		}
+		s.re = re
+	}
+	return s, nil
+}
+
+func newSegment(key string, escaped bool, glob []string, column int) segment {
+	if !escaped {
+		switch key {
+		case "#":
+			return segment{kind: anySegment, column: column}
+		case "*":
+			return segment{kind: recursiveSegment, column: column}
+		}
+	}
+	if glob != nil {
+		return segment{kind: globSegment, key: key, glob: glob, column: column}
+	}
+	return segment{kind: keySegment, key: key, column: column}
 }
@@ -0,0 +1,205 @@
+package jsonredact
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// predicate is a condition on the value a state is at. Predicates written after the last segment,
+// e.g. card_number?(len>12), check matched values; filters, e.g. #[type=="password_reset"], check objects to descend.
+type predicate struct {
+	text string // body between ?( and ) or [ and ], for debugging
+	kind predicateKind
+	op   string // comparison of len or of a field
+	n    int
+	typ  Type
+	re   *regexp.Regexp
+	path string       // gjson path of the field of a filter
+	want gjson.Result // literal the field is compared with
+}
+
+type predicateKind uint8
+
+const (
+	lenPredicate   predicateKind = iota // len>12: length of a string in runes, of an array or an object in elements, of raw JSON otherwise
+	typePredicate                       // type=string
+	regexPredicate                      // regex=^\d{3}: regex of a string or of raw JSON otherwise
+	fieldPredicate                      // type=="password_reset": a field of an object or an array compared with a JSON literal
+)
+
+var lenOperators = []string{">=", "<=", "==", "!=", ">", "<"} // longer first, so >= isn't taken for >
+
+func parsePredicate(text string) (*predicate, error) {
+	p := &predicate{text: text}
+	switch {
+	case strings.HasPrefix(text, "len"):
+		for _, op := range lenOperators {
+			if n, ok := strings.CutPrefix(text[len("len"):], op); ok {
+				var err error
+				if p.n, err = strconv.Atoi(n); err != nil {
+					return nil, fmt.Errorf("invalid length %q", n)
+				}
+				p.kind, p.op = lenPredicate, op
+				return p, nil
+			}
+		}
+	case strings.HasPrefix(text, "type="):
+		p.kind = typePredicate
+		for t := TypeNull; t <= TypeArray; t++ {
+			if t.String() == text[len("type="):] {
+				p.typ = t
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown type %q", text[len("type="):])
+	case strings.HasPrefix(text, "regex="):
+		re, err := regexp.Compile(text[len("regex="):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		p.kind, p.re = regexPredicate, re
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown predicate %q", text)
+}
+
+// parseFilter parses a filter field==literal or field!=literal, field is a gjson path.
+func parseFilter(text string) (*predicate, error) {
+	i := indexUnquoted(text, "==")
+	if j := indexUnquoted(text, "!="); i < 0 || j >= 0 && j < i {
+		i = j
+	}
+	if i <= 0 {
+		return nil, fmt.Errorf("want field==value or field!=value, got %q", text)
+	}
+	literal := text[i+2:]
+	want := gjson.Parse(literal)
+	if !gjson.Valid(literal) || want.IsObject() || want.IsArray() {
+		return nil, fmt.Errorf("invalid value %s, want a JSON string, number, boolean or null", literal)
+	}
+	return &predicate{text: text, kind: fieldPredicate, op: text[i : i+2], path: text[:i], want: want}, nil
+}
+
+// indexUnquoted is strings.Index skipping JSON strings.
+func indexUnquoted(s, substr string) int {
+	for i, inString, escaped := 0, false, false; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			inString = !inString
+		case !inString && strings.HasPrefix(s[i:], substr):
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *predicate) holds(value gjson.Result) bool {
+	switch p.kind {
+	case fieldPredicate:
+		if !value.IsObject() && !value.IsArray() {
+			return false
+		}
+		return sameValue(value.Get(p.path), p.want) == (p.op == "==")
+	case typePredicate:
+		return typeOf(value) == p.typ
+	case regexPredicate:
+		if value.Type == gjson.String {
+			return p.re.MatchString(value.Str)
+		}
+		return p.re.MatchString(value.Raw)
+	}
+	n := valueLen(value)
+	switch p.op {
+	case ">=":
+		return n >= p.n
+	case "<=":
+		return n <= p.n
+	case "==":
+		return n == p.n
+	case "!=":
+		return n != p.n
+	case ">":
+		return n > p.n
+	}
+	return n < p.n
+}
+
+func sameValue(a, b gjson.Result) bool {
+	if !a.Exists() || a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case gjson.String:
+		return a.Str == b.Str
+	case gjson.Number:
+		return a.Num == b.Num
+	}
+	return true
+}
+
+func valueLen(value gjson.Result) int {
+	switch {
+	case value.Type == gjson.String:
+		return utf8.RuneCountInString(value.Str)
+	case value.IsObject() || value.IsArray():
+		n := 0
+		value.ForEach(func(_, _ gjson.Result) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	return len(value.Raw)
+}
+
+// resolve checks guards of states against the value they are at:
+// it drops states whose predicates the value fails and recomputes the terminal rule.
+// States are filtered into buf, which may be n.states itself.
+func (n node) resolve(value gjson.Result, buf []*state) node {
+	return n.keep(buf, func(s *state) bool { return s.holds(value) })
+}
+
+// assumeHeld is resolve for values which can't be seen: every guard is assumed to hold,
+// so such values are rather redacted than leaked.
+func (n node) assumeHeld() node {
+	return n.keep(nil, func(*state) bool { return true })
+}
+
+// keep returns n with states passing holds.
+func (n node) keep(buf []*state, holds func(*state) bool) node {
+	buf = buf[:0]
+	var isTerminal, soft bool
+	var rule int
+	for _, s := range n.states {
+		if !holds(s) {
+			continue
+		}
+		switch {
+		case s.soft:
+			soft = true
+		case s.isTerminal && (!isTerminal || s.rule < rule):
+			isTerminal = true
+			rule = s.rule
+		}
+		buf = append(buf, s)
+	}
+	return node{states: buf, isTerminal: isTerminal, soft: soft, rule: rule, normalize: n.normalize}
+}
+
+func (s *state) holds(value gjson.Result) bool {
+	for _, p := range s.guards {
+		if !p.holds(value) {
+			return false
+		}
+	}
+	return true
+}
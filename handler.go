@@ -0,0 +1,180 @@
+package jsonredact
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// Type is the JSON type of a matched value.
+type Type uint8
+
+const (
+	TypeNull Type = iota
+	TypeBool
+	TypeNumber
+	TypeString
+	TypeObject
+	TypeArray
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeNull:
+		return "null"
+	case TypeBool:
+		return "bool"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeObject:
+		return "object"
+	case TypeArray:
+		return "array"
+	}
+	return "unknown"
+}
+
+// Value is a matched JSON value passed to a Handler.
+type Value struct {
+	Type Type
+	Raw  string // JSON text of the value as found in the document
+	Str  string // unquoted and unescaped string for TypeString, Raw otherwise
+	Path string // concrete path of the value in expression syntax, e.g. friends.2.name
+}
+
+// NewValue returns the Value of raw JSON without a path, e.g. to call a handler outside of a Redactor.
+func NewValue(raw string) Value {
+	return newValue(gjson.Parse(raw), "")
+}
+
+func newValue(value gjson.Result, path string) Value {
+	v := Value{Type: typeOf(value), Raw: value.Raw, Str: value.Raw, Path: path}
+	if v.Type == TypeString {
+		v.Str = value.Str
+	}
+	return v
+}
+
+func typeOf(value gjson.Result) Type {
+	switch value.Type {
+	case gjson.False, gjson.True:
+		return TypeBool
+	case gjson.Number:
+		return TypeNumber
+	case gjson.String:
+		return TypeString
+	case gjson.JSON:
+		if value.IsArray() {
+			return TypeArray
+		}
+		return TypeObject
+	}
+	return TypeNull
+}
+
+// Handler replaces matched values.
+type Handler interface {
+	// appendReplacement appends JSON replacing v to dst.
+	appendReplacement(dst []byte, v Value) []byte
+}
+
+// ValueHandler returns a string replacement for the matched value.
+// The result is written as a JSON string, quotes and control characters are escaped.
+type ValueHandler func(v Value) string
+
+func (h ValueHandler) appendReplacement(dst []byte, v Value) []byte {
+	return appendJSONString(dst, h(v))
+}
+
+// RawHandler returns JSON replacing the matched value, e.g. `0`, `null`, `{}` or `"***"`.
+// The result is written as is, use Validated to guard against invalid JSON.
+type RawHandler func(v Value) string
+
+func (h RawHandler) appendReplacement(dst []byte, v Value) []byte {
+	return append(dst, h(v)...)
+}
+
+// Validated returns a handler writing fallback instead of results of h which are not valid JSON.
+// Panics if fallback is not valid JSON.
+func (h RawHandler) Validated(fallback string) RawHandler {
+	if !gjson.Valid(fallback) {
+		panic("jsonredact: fallback is not valid JSON: " + fallback)
+	}
+	return func(v Value) string {
+		if s := h(v); gjson.Valid(s) {
+			return s
+		}
+		return fallback
+	}
+}
+
+// stringHandler is the handler of NewRedactor, it receives raw JSON of the value.
+type stringHandler func(string) string
+
+func (h stringHandler) appendReplacement(dst []byte, v Value) []byte {
+	return appendJSONString(dst, h(v.Raw))
+}
+
+const hex = "0123456789abcdef"
+
+// appendJSONString appends s to dst as a quoted JSON string.
+// Invalid UTF-8 is replaced by U+FFFD, so the result is always valid JSON.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				dst = append(dst, s[start:i]...)
+				dst = append(dst, `\ufffd`...)
+				start = i + 1
+			}
+			i += size - 1
+			continue
+		}
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		dst = append(dst, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		default:
+			dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		}
+		start = i + 1
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// pathString joins keys into a path in expression syntax.
+func pathString(keys []string) string {
+	builder := strings.Builder{}
+	for i, key := range keys {
+		if i != 0 {
+			_ = builder.WriteByte('.')
+		}
+		if key == "#" || strings.HasPrefix(key, "/") || strings.HasPrefix(key, "(?i)") {
+			_ = builder.WriteByte('\\')
+		}
+		for j := 0; j < len(key); j++ {
+			if key[j] == '.' || key[j] == '\\' || key[j] == '*' || key[j] == '[' || key[j] == '?' && j+1 < len(key) && key[j+1] == '(' {
+				_ = builder.WriteByte('\\')
+			}
+			_ = builder.WriteByte(key[j])
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/yonesko/jsonredact"
+)
+
+// envelopeVersion is the first byte of envelopes: version, key ID length, key ID, nonce, ciphertext with tag.
+const envelopeVersion = 1
+
+// Encrypt encrypts raw JSON of values by AES-GCM with key of 16, 24 or 32 bytes into base64 envelopes
+// holding keyID and a random nonce. Decrypt with the same key under keyID restores values with their types.
+func Encrypt(keyID string, key []byte) (jsonredact.ValueHandler, error) {
+	if len(keyID) > 255 {
+		return nil, errors.New("jsonredact: encrypt: key ID is longer than 255 bytes")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{envelopeVersion, byte(len(keyID))}, keyID...)
+	return func(v jsonredact.Value) string {
+		envelope := make([]byte, len(header)+aead.NonceSize(), len(header)+aead.NonceSize()+len(v.Raw)+aead.Overhead())
+		copy(envelope, header)
+		nonce := envelope[len(header):]
+		if _, err := rand.Read(nonce); err != nil {
+			return "***"
+		}
+		// the header is authenticated, so the key ID can't be swapped
+		envelope = aead.Seal(envelope, nonce, []byte(v.Raw), header)
+		return base64.StdEncoding.EncodeToString(envelope)
+	}, nil
+}
+
+// Decrypt restores values encrypted by Encrypt with keys by their IDs, run it with the expressions
+// which encrypted them without predicates: envelopes are strings longer than the values, so e.g. ssn?(type=number),
+// pin?(len<8) or code?(regex=^\d+$) would not match them, use ssn, pin or code.
+// Values which are not envelopes or fail to decrypt are kept.
+func Decrypt(keys map[string][]byte) (jsonredact.RawHandler, error) {
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q", err, id)
+		}
+		aeads[id] = aead
+	}
+	return func(v jsonredact.Value) string {
+		if raw, ok := open(aeads, v); ok {
+			return raw
+		}
+		return v.Raw
+	}, nil
+}
+
+func open(aeads map[string]cipher.AEAD, v jsonredact.Value) (string, bool) {
+	if v.Type != jsonredact.TypeString {
+		return "", false
+	}
+	envelope, err := base64.StdEncoding.DecodeString(v.Str)
+	if err != nil || len(envelope) < 2 || envelope[0] != envelopeVersion || len(envelope) < 2+int(envelope[1]) {
+		return "", false
+	}
+	header := envelope[:2+int(envelope[1])]
+	aead, ok := aeads[string(header[2:])]
+	if !ok || len(envelope) < len(header)+aead.NonceSize() {
+		return "", false
+	}
+	nonce := envelope[len(header) : len(header)+aead.NonceSize()]
+	raw, err := aead.Open(nil, nonce, envelope[len(header)+aead.NonceSize():], header)
+	if err != nil {
+		return "", false
+	}
+	return string(raw), true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("jsonredact: encrypt: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
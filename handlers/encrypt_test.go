@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/yonesko/jsonredact"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 32)
+	expressions := []string{"*.secret", "card"}
+	redactor := func(h jsonredact.Handler, err error) jsonredact.Redactor {
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := jsonredact.New(expressions, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	encryptOld := redactor(Encrypt("k1", oldKey))
+	encryptNew := redactor(Encrypt("k2", newKey))
+	decrypt := redactor(Decrypt(map[string][]byte{"k1": oldKey, "k2": newKey}))
+
+	json := `{"card":"4111\"1111","a":{"secret":{"n":1,"l":[true,null]}},"secret":42,"n":1}`
+	old, encrypted := encryptOld.Redact(json), encryptNew.Redact(json)
+	if old == json || encrypted == json || encryptNew.Redact(json) == encrypted {
+		t.Fatal("want encrypted values with random nonces", encrypted)
+	}
+	for _, e := range []string{old, encrypted} {
+		if decrypted := decrypt.Redact(e); decrypted != json {
+			t.Fatal(decrypted)
+		}
+	}
+	onlyNew := redactor(Decrypt(map[string][]byte{"k2": newKey}))
+	if decrypted := onlyNew.Redact(old); decrypted != old {
+		t.Fatal("want values of unknown keys kept", decrypted)
+	}
+	envelope, _ := base64.StdEncoding.DecodeString(gjson.Get(encrypted, "card").Str)
+	envelope[len(envelope)-1] ^= 1
+	tampered := `{"card":"` + base64.StdEncoding.EncodeToString(envelope) + `","n":"not an envelope"}`
+	if decrypted := decrypt.Redact(tampered); decrypted != tampered {
+		t.Fatal("want tampered values kept", decrypted)
+	}
+}
+
+func TestDecryptWithoutPredicates(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	encrypt, err := Encrypt("k", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt, err := Decrypt(map[string][]byte{"k": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypter, err := jsonredact.New([]string{"card?(len<20)", "ssn?(type=number)"}, encrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := `{"card":"4111111111111111","ssn":78051120,"short":"1"}`
+	encrypted := encrypter.Redact(json)
+	if encrypted == json {
+		t.Fatal(encrypted)
+	}
+	withPredicates, err := jsonredact.New([]string{"card?(len<20)", "ssn?(type=number)"}, decrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := withPredicates.Redact(encrypted); got != encrypted {
+		t.Fatal("want envelopes not matching predicates", got)
+	}
+	withoutPredicates, err := jsonredact.New([]string{"card", "ssn"}, decrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted := withoutPredicates.Redact(encrypted); decrypted != json {
+		t.Fatal(decrypted)
+	}
+}
+
+func TestEncryptErrors(t *testing.T) {
+	if _, err := Encrypt("k", []byte("short")); err == nil {
+		t.Fatal("want error of key")
+	}
+	if _, err := Encrypt(string(make([]byte, 256)), make([]byte, 16)); err == nil {
+		t.Fatal("want error of key ID")
+	}
+	if _, err := Decrypt(map[string][]byte{"k": []byte("short")}); err == nil {
+		t.Fatal("want error of key")
+	}
+}
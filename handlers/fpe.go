@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/yonesko/jsonredact"
+)
+
+// Alphabets of FF1, characters of values outside the alphabet are kept in place.
+const (
+	Digits       = "0123456789"
+	LowerLetters = "abcdefghijklmnopqrstuvwxyz"
+	UpperLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Alphanumeric = Digits + LowerLetters + UpperLetters
+)
+
+const ff1Rounds = 10
+
+// FF1 is the FF1 format-preserving encryption of NIST SP 800-38G with AES.
+// It encrypts characters of a string which are in its alphabet keeping their positions and all other characters,
+// so 4111-1111-1111-1111 encrypted over Digits is another 19 characters long string of digits and dashes.
+type FF1 struct {
+	block    cipher.Block
+	tweak    []byte
+	alphabet []rune
+	minLen   int // radix^minLen >= 1000000 as the standard requires
+}
+
+// NewFF1 returns FF1 with AES key of 16, 24 or 32 bytes, a tweak, which may be empty, and an alphabet of
+// at least 2 distinct characters.
+func NewFF1(key, tweak []byte, alphabet string) (*FF1, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("jsonredact: ff1: %w", err)
+	}
+	runes := []rune(alphabet)
+	if len(runes) < 2 || len(runes) > 1<<16 {
+		return nil, errors.New("jsonredact: ff1: alphabet must have from 2 to 65536 characters")
+	}
+	for i, r := range runes {
+		if strings.ContainsRune(string(runes[:i]), r) {
+			return nil, fmt.Errorf("jsonredact: ff1: duplicate character %q in alphabet", r)
+		}
+	}
+	minLen := 1
+	for limit, power := big.NewInt(1000000), big.NewInt(int64(len(runes))); power.Cmp(limit) < 0; minLen++ {
+		power.Mul(power, big.NewInt(int64(len(runes))))
+	}
+	return &FF1{block: block, tweak: append([]byte(nil), tweak...), alphabet: runes, minLen: max(minLen, 2)}, nil
+}
+
+// Encrypt encrypts characters of s which are in the alphabet.
+// It fails if there are too few of them to encrypt securely, e.g. fewer than 6 digits.
+func (f *FF1) Encrypt(s string) (string, error) {
+	return f.crypt(s, true)
+}
+
+// Decrypt reverses Encrypt.
+func (f *FF1) Decrypt(s string) (string, error) {
+	return f.crypt(s, false)
+}
+
+// FPE encrypts values by cipher, values with too few characters of its alphabet are replaced by "***".
+// Numbers stay numbers unless they are encrypted into something which is not a JSON number, e.g. starting
+// with zero, such numbers become strings and FPEDecrypt decrypts them into strings.
+func FPE(cipher *FF1) jsonredact.RawHandler {
+	return func(v jsonredact.Value) string {
+		encrypted, err := cipher.Encrypt(v.Str)
+		if err != nil {
+			return `"***"`
+		}
+		return fpeJSON(v.Type, encrypted)
+	}
+}
+
+// FPEDecrypt decrypts values encrypted by FPE with the same cipher, values it can't decrypt are kept.
+func FPEDecrypt(cipher *FF1) jsonredact.RawHandler {
+	return func(v jsonredact.Value) string {
+		decrypted, err := cipher.Decrypt(v.Str)
+		if err != nil {
+			return v.Raw
+		}
+		return fpeJSON(v.Type, decrypted)
+	}
+}
+
+// fpeJSON returns s as a JSON number if the value was a number and s is a JSON number, as a JSON string otherwise.
+func fpeJSON(t jsonredact.Type, s string) string {
+	if t == jsonredact.TypeNumber && s != "" && (s[0] == '-' || '0' <= s[0] && s[0] <= '9') && json.Valid([]byte(s)) {
+		return s
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (f *FF1) crypt(s string, encrypt bool) (string, error) {
+	runes := []rune(s)
+	var numerals []uint16
+	var positions []int
+	for i, r := range runes {
+		if n := f.numeral(r); n >= 0 {
+			numerals, positions = append(numerals, uint16(n)), append(positions, i)
+		}
+	}
+	if len(numerals) < f.minLen {
+		return "", fmt.Errorf("jsonredact: ff1: want at least %d characters of the alphabet, got %d", f.minLen, len(numerals))
+	}
+	numerals = f.rounds(numerals, encrypt)
+	for i, p := range positions {
+		runes[p] = f.alphabet[numerals[i]]
+	}
+	return string(runes), nil
+}
+
+func (f *FF1) numeral(r rune) int {
+	for i, a := range f.alphabet {
+		if a == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// rounds runs the Feistel rounds of algorithms 7 and 8 of SP 800-38G on numerals.
+func (f *FF1) rounds(x []uint16, encrypt bool) []uint16 {
+	radix := big.NewInt(int64(len(f.alphabet)))
+	n, t := len(x), len(f.tweak)
+	u := n / 2
+	v := n - u
+	a, b := append([]uint16(nil), x[:u]...), append([]uint16(nil), x[u:]...)
+	// bytes of a number of v numerals and of the round function output
+	bLen := (bitLen(radix, v) + 7) / 8
+	d := 4*((bLen+3)/4) + 4
+
+	p := make([]byte, 16, 16+t+16+bLen)
+	p[0], p[1], p[2] = 1, 2, 1
+	p[3], p[4], p[5] = byte(len(f.alphabet)>>16), byte(len(f.alphabet)>>8), byte(len(f.alphabet))
+	p[6], p[7] = 10, byte(u)
+	binary.BigEndian.PutUint32(p[8:12], uint32(n))
+	binary.BigEndian.PutUint32(p[12:16], uint32(t))
+
+	q := append(p, f.tweak...)
+	q = append(q, make([]byte, (16-(t+bLen+1)%16)%16)...)
+	roundStart := len(q)
+	mod := [2]*big.Int{new(big.Int).Exp(radix, big.NewInt(int64(u)), nil), new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)}
+	s := make([]byte, (d+15)/16*16)
+	var y, c big.Int
+	for round := 0; round < ff1Rounds; round++ {
+		i := round
+		if !encrypt {
+			i = ff1Rounds - 1 - round
+		}
+		input := b
+		if !encrypt {
+			input = a
+		}
+		q = append(append(q[:roundStart], byte(i)), make([]byte, bLen)...)
+		num(radix, input).FillBytes(q[roundStart+1:])
+		f.prf(s[:16], q)
+		for j := 16; j < len(s); j += 16 {
+			copy(s[j:j+16], s[:16])
+			binary.BigEndian.PutUint64(s[j+8:j+16], binary.BigEndian.Uint64(s[8:16])^uint64(j/16))
+			f.block.Encrypt(s[j:j+16], s[j:j+16])
+		}
+		y.SetBytes(s[:d])
+		m := mod[i%2]
+		length := u
+		if i%2 == 1 {
+			length = v
+		}
+		if encrypt {
+			c.Add(num(radix, a), &y)
+			c.Mod(&c, m)
+			a, b = b, str(radix, &c, length)
+		} else {
+			c.Sub(num(radix, b), &y)
+			c.Mod(&c, m)
+			a, b = str(radix, &c, length), a
+		}
+	}
+	return append(a, b...)
+}
+
+// prf is CBC-MAC of data with a zero IV, len(data) is a multiple of 16.
+func (f *FF1) prf(dst, data []byte) {
+	clear(dst)
+	for i := 0; i < len(data); i += 16 {
+		xor16(dst, data[i:i+16])
+		f.block.Encrypt(dst, dst)
+	}
+}
+
+func xor16(dst, src []byte) {
+	for i := range 16 {
+		dst[i] ^= src[i]
+	}
+}
+
+// bitLen returns ceil(n*log2(radix)), bits needed for any number of n numerals.
+func bitLen(radix *big.Int, n int) int {
+	limit := new(big.Int).Exp(radix, big.NewInt(int64(n)), nil)
+	return limit.Sub(limit, big.NewInt(1)).BitLen()
+}
+
+// num returns the number of numerals, the most significant first.
+func num(radix *big.Int, numerals []uint16) *big.Int {
+	x := new(big.Int)
+	for _, n := range numerals {
+		x.Mul(x, radix)
+		x.Add(x, big.NewInt(int64(n)))
+	}
+	return x
+}
+
+// str returns length numerals of x, the most significant first.
+func str(radix, x *big.Int, length int) []uint16 {
+	numerals := make([]uint16, length)
+	x = new(big.Int).Set(x)
+	var digit big.Int
+	for i := length - 1; i >= 0; i-- {
+		x.DivMod(x, radix, &digit)
+		numerals[i] = uint16(digit.Int64())
+	}
+	return numerals
+}
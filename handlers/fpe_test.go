@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/yonesko/jsonredact"
+)
+
+func hexBytes(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Test vectors are samples of FF1 by NIST.
+func TestFF1(t *testing.T) {
+	tests := []struct {
+		key, tweak, alphabet string
+		plain, encrypted     string
+	}{
+		{
+			key:       "2B7E151628AED2A6ABF7158809CF4F3C",
+			alphabet:  Digits,
+			plain:     "0123456789",
+			encrypted: "2433477484",
+		},
+		{
+			key:       "2B7E151628AED2A6ABF7158809CF4F3C",
+			tweak:     "39383736353433323130",
+			alphabet:  Digits,
+			plain:     "0123456789",
+			encrypted: "6124200773",
+		},
+		{
+			key:       "2B7E151628AED2A6ABF7158809CF4F3C",
+			tweak:     "3737373770717273373737",
+			alphabet:  Digits + LowerLetters,
+			plain:     "0123456789abcdefghi",
+			encrypted: "a9tv40mll9kdu509eum",
+		},
+		{
+			key:       "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94",
+			tweak:     "3737373770717273373737",
+			alphabet:  Digits + LowerLetters,
+			plain:     "0123456789abcdefghi",
+			encrypted: "xs8a0azh2avyalyzuwd",
+		},
+	}
+	for _, tt := range tests {
+		f, err := NewFF1(hexBytes(tt.key), hexBytes(tt.tweak), tt.alphabet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, err := f.Encrypt(tt.plain)
+		if err != nil || encrypted != tt.encrypted {
+			t.Fatalf("Encrypt(%q) = %q, %v, want %q", tt.plain, encrypted, err, tt.encrypted)
+		}
+		decrypted, err := f.Decrypt(encrypted)
+		if err != nil || decrypted != tt.plain {
+			t.Fatalf("Decrypt(%q) = %q, %v, want %q", encrypted, decrypted, err, tt.plain)
+		}
+	}
+}
+
+func TestNewFF1Errors(t *testing.T) {
+	key := hexBytes("2B7E151628AED2A6ABF7158809CF4F3C")
+	for _, alphabet := range []string{"", "a", "abca"} {
+		if _, err := NewFF1(key, nil, alphabet); err == nil {
+			t.Fatalf("want error for alphabet %q", alphabet)
+		}
+	}
+	if _, err := NewFF1(key[:5], nil, Digits); err == nil {
+		t.Fatal("want error for key")
+	}
+}
+
+func TestFPE(t *testing.T) {
+	f, err := NewFF1(hexBytes("2B7E151628AED2A6ABF7158809CF4F3C"), nil, Digits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expressions := []string{"card", "ssn", "short", "n"}
+	encrypt, err := jsonredact.New(expressions, FPE(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt, err := jsonredact.New(expressions, FPEDecrypt(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := `{"card":"4111-1111-1111-1111","ssn":"078-05-1120","short":"12345","n":1234567}`
+	encrypted := encrypt.Redact(json)
+	want := `^\{"card":"\d{4}-\d{4}-\d{4}-\d{4}","ssn":"\d{3}-\d{2}-\d{4}","short":"\*\*\*","n":[1-9]\d{6}}$`
+	if !regexp.MustCompile(want).MatchString(encrypted) || encrypted == json {
+		t.Fatal(encrypted)
+	}
+	if decrypted := decrypt.Redact(encrypted); decrypted != `{"card":"4111-1111-1111-1111","ssn":"078-05-1120","short":"***","n":1234567}` {
+		t.Fatal(decrypted)
+	}
+	// a number encrypted into digits starting with zero is not a JSON number
+	n := 100000
+	for encrypted, _ := f.Encrypt(strconv.Itoa(n)); encrypted[0] != '0'; encrypted, _ = f.Encrypt(strconv.Itoa(n)) {
+		n++
+	}
+	json = `{"n":` + strconv.Itoa(n) + `}`
+	encrypted = encrypt.Redact(json)
+	if !regexp.MustCompile(`^\{"n":"0\d{5}"}$`).MatchString(encrypted) {
+		t.Fatal(encrypted)
+	}
+	if decrypted := decrypt.Redact(encrypted); decrypted != `{"n":"`+strconv.Itoa(n)+`"}` {
+		t.Fatal(decrypted)
+	}
+}
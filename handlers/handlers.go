@@ -0,0 +1,124 @@
+// Package handlers provides ready-made handlers for jsonredact.
+//
+// Masks work on decoded strings and count characters, not bytes, so multibyte characters are never split.
+// Their results are written by jsonredact as escaped JSON strings.
+package handlers
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yonesko/jsonredact"
+)
+
+// Mask is the character replacing hidden characters.
+const Mask = '*'
+
+// Placeholder replaces values by s.
+func Placeholder(s string) jsonredact.ValueHandler {
+	return func(jsonredact.Value) string {
+		return s
+	}
+}
+
+// KeepFirst keeps the first n characters of values and masks the rest.
+func KeepFirst(n int) jsonredact.ValueHandler {
+	return func(v jsonredact.Value) string {
+		return keep(v.Str, n, 0)
+	}
+}
+
+// KeepLast keeps the last n characters of values and masks the rest.
+func KeepLast(n int) jsonredact.ValueHandler {
+	return func(v jsonredact.Value) string {
+		return keep(v.Str, 0, n)
+	}
+}
+
+// PreserveLength masks every character of values.
+func PreserveLength() jsonredact.ValueHandler {
+	return func(v jsonredact.Value) string {
+		return keep(v.Str, 0, 0)
+	}
+}
+
+// Email masks the local part of email addresses, e.g. ***@x.com. Values without '@' are masked entirely.
+func Email() jsonredact.ValueHandler {
+	return func(v jsonredact.Value) string {
+		at := strings.LastIndexByte(v.Str, '@')
+		if at < 0 {
+			return "***"
+		}
+		return "***" + v.Str[at:]
+	}
+}
+
+// Card keeps the last 4 digits of card numbers, e.g. ****1234. Separators are dropped, values with fewer than
+// 8 digits are masked entirely as the last digits would reveal too much of them.
+func Card() jsonredact.ValueHandler {
+	return func(v jsonredact.Value) string {
+		digits := make([]byte, 0, len(v.Str))
+		for i := 0; i < len(v.Str); i++ {
+			if '0' <= v.Str[i] && v.Str[i] <= '9' {
+				digits = append(digits, v.Str[i])
+			}
+		}
+		if len(digits) < 8 {
+			return "****"
+		}
+		return "****" + string(digits[len(digits)-4:])
+	}
+}
+
+// Null replaces values by null.
+func Null() jsonredact.RawHandler {
+	return func(jsonredact.Value) string {
+		return `null`
+	}
+}
+
+// Zero replaces values by the zero value of their type: "", 0, false, {}, [] or null,
+// so consumers decoding into typed structs keep working.
+func Zero() jsonredact.RawHandler {
+	return func(v jsonredact.Value) string {
+		switch v.Type {
+		case jsonredact.TypeString:
+			return `""`
+		case jsonredact.TypeNumber:
+			return `0`
+		case jsonredact.TypeBool:
+			return `false`
+		case jsonredact.TypeObject:
+			return `{}`
+		case jsonredact.TypeArray:
+			return `[]`
+		}
+		return `null`
+	}
+}
+
+// Func adapts h to the handler of jsonredact.NewRedactor, which receives raw JSON of values.
+// Values passed to h have no path.
+func Func(h jsonredact.ValueHandler) func(string) string {
+	return func(raw string) string {
+		return h(jsonredact.NewValue(raw))
+	}
+}
+
+// keep masks s but the first and the last characters.
+func keep(s string, first, last int) string {
+	n := utf8.RuneCountInString(s)
+	first, last = max(min(first, n), 0), max(min(last, n-min(first, n)), 0)
+	builder := strings.Builder{}
+	builder.Grow(len(s))
+	i := 0
+	for _, r := range s {
+		if i < first || i >= n-last {
+			_, _ = builder.WriteRune(r)
+		} else {
+			_, _ = builder.WriteRune(Mask)
+		}
+		i++
+	}
+	return builder.String()
+}
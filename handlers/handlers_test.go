@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/yonesko/jsonredact"
+)
+
+func TestHandlers(t *testing.T) {
+	json := `{"s":"a\"bcdé\\f","email":"john.doe@x.com","card":"4111 1111 1111 1234","n":4111111111111234,"b":true,"o":{"a":1},"l":[1],"z":null}`
+	tests := []struct {
+		name    string
+		handler jsonredact.Handler
+		want    string
+	}{
+		{
+			name:    "placeholder",
+			handler: Placeholder(`"REDACTED"`),
+			want:    `{"s":"\"REDACTED\"","email":"\"REDACTED\"","card":"\"REDACTED\"","n":"\"REDACTED\"","b":"\"REDACTED\"","o":"\"REDACTED\"","l":"\"REDACTED\"","z":"\"REDACTED\""}`,
+		},
+		{
+			name:    "keep first",
+			handler: KeepFirst(2),
+			want:    `{"s":"a\"******","email":"jo************","card":"41*****************","n":"41**************","b":"tr**","o":"{\"*****","l":"[1*","z":"nu**"}`,
+		},
+		{
+			name:    "keep last",
+			handler: KeepLast(2),
+			want:    `{"s":"******\\f","email":"************om","card":"*****************34","n":"**************34","b":"**ue","o":"*****1}","l":"*1]","z":"**ll"}`,
+		},
+		{
+			name:    "preserve length",
+			handler: PreserveLength(),
+			want:    `{"s":"********","email":"**************","card":"*******************","n":"****************","b":"****","o":"*******","l":"***","z":"****"}`,
+		},
+		{
+			name:    "email",
+			handler: Email(),
+			want:    `{"s":"***","email":"***@x.com","card":"***","n":"***","b":"***","o":"***","l":"***","z":"***"}`,
+		},
+		{
+			name:    "card",
+			handler: Card(),
+			want:    `{"s":"****","email":"****","card":"****1234","n":"****1234","b":"****","o":"****","l":"****","z":"****"}`,
+		},
+		{
+			name:    "null",
+			handler: Null(),
+			want:    `{"s":null,"email":null,"card":null,"n":null,"b":null,"o":null,"l":null,"z":null}`,
+		},
+		{
+			name:    "zero",
+			handler: Zero(),
+			want:    `{"s":"","email":"","card":"","n":0,"b":false,"o":{},"l":[],"z":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor, err := jsonredact.New([]string{"#"}, tt.handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redactor.Redact(json); got != tt.want {
+				t.Fatalf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keep(t *testing.T) {
+	tests := []struct {
+		s           string
+		first, last int
+		want        string
+	}{
+		{s: "", first: 2, last: 2, want: ""},
+		{s: "abc", first: 5, last: 0, want: "abc"},
+		{s: "abc", first: 0, last: 5, want: "abc"},
+		{s: "abcdef", first: 4, last: 4, want: "abcdef"},
+		{s: "abcdef", first: -1, last: 1, want: "*****f"},
+		{s: "привет", first: 1, last: 1, want: "п****т"},
+	}
+	for _, tt := range tests {
+		if got := keep(tt.s, tt.first, tt.last); got != tt.want {
+			t.Fatalf("keep(%q, %d, %d) = %q, want %q", tt.s, tt.first, tt.last, got, tt.want)
+		}
+	}
+}
+
+func TestFunc(t *testing.T) {
+	redactor := jsonredact.NewRedactor([]string{"card", "email"}, Func(Card()))
+	if got := redactor.Redact(`{"card":"4111-1111-1111-1234","email":5}`); got != `{"card":"****1234","email":"****"}` {
+		t.Fatal(got)
+	}
+}
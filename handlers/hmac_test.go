@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/yonesko/jsonredact"
+)
+
+func TestHMAC(t *testing.T) {
+	key := []byte("secret")
+	tests := []struct {
+		name string
+		opts HMACOptions
+		want string
+	}{
+		{
+			name: "digest",
+			want: `{"id":"93c121e7aa437a1e01e3c512c6f0ce3c821a839025dca4408f85616de4aaee70","n":42,"user":{"id":"93c121e7aa437a1e01e3c512c6f0ce3c821a839025dca4408f85616de4aaee70"}}`,
+		},
+		{
+			name: "prefix, key and length",
+			opts: HMACOptions{Prefix: "usr_", KeyID: "k1", Length: 12},
+			want: `{"id":"usr_k1:93c121e7aa43","n":42,"user":{"id":"usr_k1:93c121e7aa43"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor, err := jsonredact.New([]string{"*.id"}, HMAC(key, tt.opts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redactor.Redact(`{"id":42,"n":42,"user":{"id":"42"}}`); got != tt.want {
+				t.Fatal(got)
+			}
+		})
+	}
+	other := HMAC([]byte("other"), HMACOptions{KeyID: "k2"})(jsonredact.Value{Str: "42"})
+	if other[:3] != "k2:" || other[3:] == "93c121e7aa437a1e01e3c512c6f0ce3c821a839025dca4408f85616de4aaee70" {
+		t.Fatal(other)
+	}
+}
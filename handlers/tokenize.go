@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/yonesko/jsonredact"
+)
+
+const (
+	defaultTokenPrefix = "tok_"
+	tokenBytes         = 16
+)
+
+// TokenizeOptions configures Tokenize.
+type TokenizeOptions struct {
+	Prefix  string      // written before random hex of tokens, "tok_" if empty
+	OnError func(error) // called when the vault fails, the value is replaced by "***" then
+}
+
+// Tokenize replaces values by random tokens, e.g. tok_8c1f..., storing raw JSON of values in vault,
+// so Restore can bring them back.
+func Tokenize(vault Vault, opts TokenizeOptions) jsonredact.ValueHandler {
+	prefix := tokenPrefix(opts.Prefix)
+	return func(v jsonredact.Value) string {
+		random := make([]byte, tokenBytes)
+		_, _ = rand.Read(random)
+		token := prefix + hex.EncodeToString(random)
+		if err := vault.Store(token, v.Raw); err != nil {
+			if opts.OnError != nil {
+				opts.OnError(err)
+			}
+			return "***"
+		}
+		return token
+	}
+}
+
+// Restore replaces tokens made by Tokenize with prefix in json by original values from vault.
+// Values which were whole strings, numbers or objects get their types back, tokens inside strings are replaced by text.
+// Tokens missing in vault are kept. Only callers allowed to see the original values should have access to the vault.
+func Restore(json string, vault Vault, prefix string) (string, error) {
+	prefix = tokenPrefix(prefix)
+	var loadErr error
+	load := func(token string) (string, bool) {
+		raw, ok, err := vault.Load(token)
+		if err != nil && loadErr == nil {
+			loadErr = err
+		}
+		return raw, ok && err == nil
+	}
+	// partial rules use JSON strings unquoted, so the same handler restores tokens inside strings
+	restore := jsonredact.RawHandler(func(v jsonredact.Value) string {
+		if raw, ok := load(v.Str); ok {
+			return raw
+		}
+		return v.Raw
+	})
+	redactor, err := jsonredact.NewFromRules([]jsonredact.Rule{
+		{Handler: restore, Detector: jsonredact.DetectorFunc(func(s string) [][2]int {
+			if len(s) != len(prefix)+tokenBytes*2 || !isToken(s, prefix) {
+				return nil
+			}
+			return [][2]int{{0, len(s)}}
+		})},
+		{Handler: restore, Detector: tokenDetector(prefix), Partial: true},
+	})
+	if err != nil {
+		return "", err
+	}
+	restored := redactor.Redact(json)
+	if loadErr != nil {
+		return "", loadErr
+	}
+	return restored, nil
+}
+
+func tokenPrefix(prefix string) string {
+	if prefix == "" {
+		return defaultTokenPrefix
+	}
+	return prefix
+}
+
+// tokenDetector detects tokens with prefix inside strings.
+func tokenDetector(prefix string) jsonredact.Detector {
+	return jsonredact.DetectorFunc(func(s string) [][2]int {
+		var found [][2]int
+		for i := 0; ; {
+			start := strings.Index(s[i:], prefix)
+			if start < 0 {
+				return found
+			}
+			start += i
+			if isToken(s[start:], prefix) {
+				end := start + len(prefix) + tokenBytes*2
+				found = append(found, [2]int{start, end})
+				i = end
+				continue
+			}
+			i = start + 1
+		}
+	})
+}
+
+// isToken reports whether s starts with a token with prefix.
+func isToken(s, prefix string) bool {
+	if len(s) < len(prefix)+tokenBytes*2 || !strings.HasPrefix(s, prefix) {
+		return false
+	}
+	for _, c := range s[len(prefix) : len(prefix)+tokenBytes*2] {
+		if ('0' > c || c > '9') && ('a' > c || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/yonesko/jsonredact"
+)
+
+func TestTokenizeRestore(t *testing.T) {
+	json := `{"id":42,"email":"a\"b@x.com","card":{"n":"4111"},"msg":"user john@x.com failed","n":1}`
+	vault := NewMemoryVault()
+	redactor, err := jsonredact.NewFromRules([]jsonredact.Rule{
+		{Expression: "id", Handler: Tokenize(vault, TokenizeOptions{})},
+		{Expression: "email", Handler: Tokenize(vault, TokenizeOptions{})},
+		{Expression: "card", Handler: Tokenize(vault, TokenizeOptions{})},
+		{Expression: "msg", Handler: Tokenize(vault, TokenizeOptions{}), Detector: jsonredact.DetectEmails(), Partial: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted := redactor.Redact(json)
+	if !regexp.MustCompile(`^\{"id":"tok_[0-9a-f]{32}","email":"tok_[0-9a-f]{32}","card":"tok_[0-9a-f]{32}","msg":"user tok_[0-9a-f]{32} failed","n":1}$`).MatchString(redacted) {
+		t.Fatal(redacted)
+	}
+	if again := redactor.Redact(json); again == redacted {
+		t.Fatal("want random tokens")
+	}
+	restored, err := Restore(redacted, vault, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != json {
+		t.Fatal(restored)
+	}
+	unknown := `{"id":"tok_00000000000000000000000000000000"}`
+	if restored, err := Restore(unknown, vault, ""); err != nil || restored != unknown {
+		t.Fatal(restored, err)
+	}
+}
+
+type failingVault struct{ err error }
+
+func (v failingVault) Store(string, string) error        { return v.err }
+func (v failingVault) Load(string) (string, bool, error) { return "", false, v.err }
+
+func TestTokenizeVaultErrors(t *testing.T) {
+	vault := failingVault{err: errors.New("down")}
+	var got error
+	redactor, err := jsonredact.New([]string{"a"}, Tokenize(vault, TokenizeOptions{Prefix: "t_", OnError: func(err error) { got = err }}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redacted := redactor.Redact(`{"a":"secret"}`); redacted != `{"a":"***"}` || got != vault.err {
+		t.Fatal(redacted, got)
+	}
+	if _, err := Restore(`{"a":"t_00000000000000000000000000000000"}`, vault, "t_"); err != vault.err {
+		t.Fatal(err)
+	}
+}
+
+func TestFileVault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.jsonl")
+	vault, err := OpenFileVault(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor, err := jsonredact.New([]string{"#"}, Tokenize(vault, TokenizeOptions{Prefix: "v_"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := `{"a":"x\ny","b":[1,{"c":null}]}`
+	redacted := redactor.Redact(json)
+	if err := vault.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.Store("v_1", `1`); err == nil {
+		t.Fatal("want error of closed vault")
+	}
+	reopened, err := OpenFileVault(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if restored, err := Restore(redacted, reopened, "v_"); err != nil || restored != json {
+		t.Fatal(restored, err)
+	}
+}
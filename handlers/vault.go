@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Vault keeps original values of tokens made by Tokenize.
+type Vault interface {
+	// Store keeps raw JSON of a value under token.
+	Store(token, raw string) error
+	// Load returns raw JSON of the value stored under token, ok is false if there is none.
+	Load(token string) (raw string, ok bool, err error)
+}
+
+// MemoryVault is a Vault in memory, safe for concurrent use.
+type MemoryVault struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func NewMemoryVault() *MemoryVault {
+	return &MemoryVault{values: map[string]string{}}
+}
+
+func (v *MemoryVault) Store(token, raw string) error {
+	v.mu.Lock()
+	v.values[token] = raw
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *MemoryVault) Load(token string) (string, bool, error) {
+	v.mu.RLock()
+	raw, ok := v.values[token]
+	v.mu.RUnlock()
+	return raw, ok, nil
+}
+
+// FileVault is a Vault appending values to a file of JSON lines {"token":...,"raw":...}, safe for concurrent use.
+// Values are also kept in memory, so loads don't read the file.
+type FileVault struct {
+	memory MemoryVault
+	mu     sync.Mutex
+	file   *os.File
+}
+
+type fileVaultRecord struct {
+	Token string          `json:"token"`
+	Raw   json.RawMessage `json:"raw"`
+}
+
+// OpenFileVault opens or creates the file vault at path readable only by its owner.
+func OpenFileVault(path string) (*FileVault, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	v := &FileVault{memory: MemoryVault{values: map[string]string{}}, file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record fileVaultRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("jsonredact: vault %s: line %d: %w", path, line, err)
+		}
+		v.memory.values[record.Token] = string(record.Raw)
+	}
+	if err := scanner.Err(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *FileVault) Store(token, raw string) error {
+	line, err := json.Marshal(fileVaultRecord{Token: token, Raw: json.RawMessage(raw)})
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.file == nil {
+		return errors.New("jsonredact: vault is closed")
+	}
+	if _, err := v.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return v.memory.Store(token, raw)
+}
+
+func (v *FileVault) Load(token string) (string, bool, error) {
+	return v.memory.Load(token)
+}
+
+// Close closes the file, loads keep working.
+func (v *FileVault) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.file == nil {
+		return nil
+	}
+	err := v.file.Close()
+	v.file = nil
+	return err
+}
@@ -0,0 +1,282 @@
+package jsonredact
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Expressions of headers and query parameters start with these keys, e.g. headers.authorization or query.token,
+// so one set of expressions covers JSON bodies, headers and URLs.
+const (
+	HeadersKey = "headers"
+	QueryKey   = "query"
+)
+
+// RedactHeader returns a copy of h with redacted values of headers matching HeadersKey expressions.
+// Header names are matched in lower case, e.g. headers.authorization.
+func (r Redactor) RedactHeader(h http.Header) http.Header {
+	automata := r.automata.next(HeadersKey, nil)
+	redacted := h.Clone()
+	if len(automata.states) == 0 && !automata.isTerminal {
+		return redacted
+	}
+	for name, values := range redacted {
+		key := strings.ToLower(name)
+		next := automata
+		if !automata.isTerminal {
+			next = automata.next(key, nil)
+		}
+		r.redactTexts(next, values, HeadersKey, key)
+	}
+	return redacted
+}
+
+// RedactValues returns a copy of q with redacted values of parameters matching QueryKey expressions, e.g. query.token.
+func (r Redactor) RedactValues(q url.Values) url.Values {
+	redacted := make(url.Values, len(q))
+	automata := r.automata.next(QueryKey, nil)
+	for key, values := range q {
+		redacted[key] = r.redactParam(automata, key, append([]string(nil), values...))
+	}
+	return redacted
+}
+
+// RedactURL redacts query parameters of rawURL like RedactValues keeping their order and encoding.
+// Strings which are not URLs are returned as is.
+func (r Redactor) RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	automata := r.automata.next(QueryKey, nil)
+	if len(automata.states) == 0 && !automata.isTerminal {
+		return rawURL
+	}
+	params := strings.Split(u.RawQuery, "&")
+	redactedAny := false
+	for i, param := range params {
+		rawKey, rawValue, _ := strings.Cut(param, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			continue
+		}
+		if redacted := r.redactParam(automata, key, []string{value}); redacted[0] != value {
+			params[i] = rawKey + "=" + url.QueryEscape(redacted[0])
+			redactedAny = true
+		}
+	}
+	if !redactedAny {
+		return rawURL
+	}
+	// the query is between the first '?' and the fragment, the rest of rawURL is kept byte for byte
+	start := strings.IndexByte(rawURL, '?') + 1
+	return rawURL[:start] + strings.Join(params, "&") + rawURL[start+len(u.RawQuery):]
+}
+
+func (r Redactor) redactParam(automata node, key string, values []string) []string {
+	next := automata
+	if !automata.isTerminal {
+		next = automata.next(key, nil)
+	}
+	r.redactTexts(next, values, QueryKey, key)
+	return values
+}
+
+// redactTexts replaces values matched by automata in place.
+func (r Redactor) redactTexts(automata node, values []string, path ...string) {
+	if !automata.isTerminal && !automata.guarded && !automata.soft {
+		return
+	}
+	for i := range values {
+		next := automata
+		if next.guarded {
+			next = next.resolve(gjson.ParseBytes(appendJSONString(nil, values[i])), nil)
+		}
+		if next.isTerminal {
+			values[i] = r.replaceText(r.handlers[next.rule], values[i], path...)
+		} else if next.soft {
+			if _, handler, ok := r.detect(next, values[i]); ok {
+				values[i] = r.replaceText(handler, values[i], path...)
+			}
+		}
+	}
+}
+
+// replaceText runs handler on a text value, replacements other than JSON strings are used as text, e.g. null.
+func (r Redactor) replaceText(handler Handler, text string, path ...string) string {
+	raw := appendJSONString(nil, text)
+	v := handlerValue(handler, gjson.ParseBytes(raw), func() []string { return path })
+	replacement := gjson.ParseBytes(handler.appendReplacement(nil, v))
+	if replacement.Type == gjson.String {
+		return replacement.Str
+	}
+	return replacement.Raw
+}
+
+// AuditRecord is an HTTP exchange with redacted headers, URL and JSON bodies.
+type AuditRecord struct {
+	Method                string
+	URL                   string // redacted by RedactURL
+	Status                int
+	RequestHeader         http.Header // redacted by RedactHeader
+	ResponseHeader        http.Header // redacted by RedactHeader
+	RequestBody           []byte      // redacted JSON body, nil if body is not valid JSON or truncated
+	RequestBodyTruncated  bool        // body is larger than MaxBodySize or the handler didn't read it to the end
+	ResponseBody          []byte      // redacted JSON body, nil if body is not valid JSON or truncated
+	ResponseBodyTruncated bool        // body is larger than MaxBodySize
+}
+
+// AuditOptions configures AuditMiddleware.
+type AuditOptions struct {
+	MaxBodySize int // bytes of every body to capture, 64 KiB if zero
+}
+
+const defaultMaxBodySize = 64 << 10
+
+// AuditMiddleware passes headers, URL and JSON request and response bodies redacted by r to sink after the handler returns.
+// Bodies are captured while the handler reads and writes them, so neither the client nor the handler sees a change,
+// and only if Content-Type is JSON and Content-Encoding is not set. Flushes of streaming responses pass through.
+func AuditMiddleware(r Redactor, sink func(*http.Request, AuditRecord), opts AuditOptions) func(http.Handler) http.Handler {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaultMaxBodySize
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			var requestBody *captureReader
+			if req.Body != nil && isJSONBody(req.Header) {
+				requestBody = &captureReader{ReadCloser: req.Body, capture: capture{max: opts.MaxBodySize}}
+				req.Body = requestBody
+			}
+			rw := &auditResponseWriter{ResponseWriter: w, capture: capture{max: opts.MaxBodySize}}
+			next.ServeHTTP(rw, req)
+			record := AuditRecord{
+				Method:         req.Method,
+				URL:            r.RedactURL(req.URL.String()),
+				Status:         rw.status,
+				RequestHeader:  r.RedactHeader(req.Header),
+				ResponseHeader: r.RedactHeader(w.Header()),
+			}
+			if record.Status == 0 {
+				record.Status = http.StatusOK
+			}
+			if requestBody != nil {
+				record.RequestBody, record.RequestBodyTruncated = requestBody.redacted(r)
+			}
+			if rw.capturing {
+				record.ResponseBody, record.ResponseBodyTruncated = rw.redacted(r)
+			}
+			sink(req, record)
+		})
+	}
+}
+
+func isJSONBody(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// capture keeps up to max bytes.
+type capture struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (c *capture) write(p []byte) {
+	if c.truncated {
+		return
+	}
+	if len(c.buf)+len(p) > c.max {
+		c.truncated, c.buf = true, nil
+		return
+	}
+	c.buf = append(c.buf, p...)
+}
+
+// redacted returns the captured body redacted. Truncated and invalid bodies are not returned:
+// values cut off are left as they are by redaction, so they may leak.
+func (c *capture) redacted(r Redactor) ([]byte, bool) {
+	if c.truncated {
+		return nil, true
+	}
+	if c.buf == nil || !gjson.ValidBytes(c.buf) {
+		return nil, false
+	}
+	return r.RedactBytes(c.buf), false
+}
+
+type captureReader struct {
+	io.ReadCloser
+	capture
+	eof bool
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.write(p[:n])
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// redacted treats bodies the handler didn't read to the end as truncated, unless they are valid JSON:
+// decoders may stop reading right after the value.
+func (r *captureReader) redacted(redactor Redactor) ([]byte, bool) {
+	if r.buf != nil && !r.eof && !gjson.ValidBytes(r.buf) {
+		r.truncated, r.buf = true, nil
+	}
+	return r.capture.redacted(redactor)
+}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	capture
+	status    int
+	capturing bool
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 { // informational responses may precede the final one
+		w.status = status
+		w.capturing = isJSONBody(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if w.capturing {
+		w.write(p[:n])
+	}
+	return n, err
+}
+
+func (w *auditResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,218 @@
+package jsonredact
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	redactor := NewRedactor([]string{"password", "token"}, handler)
+	tests := []struct {
+		name            string
+		requestType     string
+		requestBody     string
+		responseType    string
+		responseBody    string
+		status          int
+		maxBodySize     int
+		wantRecord      AuditRecord
+		wantHandlerBody string
+	}{
+		{
+			name:         "json bodies",
+			requestType:  "application/json; charset=utf-8",
+			requestBody:  `{"user":"bob","password":"123"}`,
+			responseType: "application/json",
+			responseBody: `{"token":"abc"}`,
+			status:       http.StatusCreated,
+			wantRecord: AuditRecord{Method: "POST", URL: "/login?a=1", Status: http.StatusCreated,
+				RequestBody: []byte(`{"user":"bob","password":"REDACTED"}`), ResponseBody: []byte(`{"token":"REDACTED"}`)},
+		},
+		{
+			name:         "problem json response, text request",
+			requestType:  "text/plain",
+			requestBody:  `password=123`,
+			responseType: "application/problem+json",
+			responseBody: `{"title":"bad","password":"x"}`,
+			wantRecord: AuditRecord{Method: "POST", URL: "/login?a=1", Status: http.StatusOK,
+				ResponseBody: []byte(`{"title":"bad","password":"REDACTED"}`)},
+		},
+		{
+			name:         "truncated",
+			requestType:  "application/json",
+			requestBody:  `{"password":"` + strings.Repeat("x", 100) + `"}`,
+			responseType: "application/json",
+			responseBody: `{"token":"` + strings.Repeat("x", 100) + `"}`,
+			maxBodySize:  50,
+			wantRecord: AuditRecord{Method: "POST", URL: "/login?a=1", Status: http.StatusOK,
+				RequestBodyTruncated: true, ResponseBodyTruncated: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got AuditRecord
+			var handlerBody string
+			h := AuditMiddleware(redactor, func(_ *http.Request, record AuditRecord) { got = record }, AuditOptions{MaxBodySize: tt.maxBodySize})(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					handlerBody = string(body)
+					w.Header().Set("Content-Type", tt.responseType)
+					if tt.status != 0 {
+						w.WriteHeader(tt.status)
+					}
+					_, _ = io.WriteString(w, tt.responseBody[:5])
+					_, _ = io.WriteString(w, tt.responseBody[5:])
+				}))
+			req := httptest.NewRequest("POST", "/login?a=1", strings.NewReader(tt.requestBody))
+			req.Header.Set("Content-Type", tt.requestType)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if handlerBody != tt.requestBody {
+				t.Fatalf("handler got %s", handlerBody)
+			}
+			if rec.Body.String() != tt.responseBody {
+				t.Fatalf("client got %s", rec.Body.String())
+			}
+			if got.Method != tt.wantRecord.Method || got.URL != tt.wantRecord.URL || got.Status != tt.wantRecord.Status ||
+				string(got.RequestBody) != string(tt.wantRecord.RequestBody) || string(got.ResponseBody) != string(tt.wantRecord.ResponseBody) ||
+				got.RequestBodyTruncated != tt.wantRecord.RequestBodyTruncated || got.ResponseBodyTruncated != tt.wantRecord.ResponseBodyTruncated {
+				t.Fatalf("got %+v\nwant %+v", got, tt.wantRecord)
+			}
+		})
+	}
+}
+
+func TestAuditMiddlewareStreaming(t *testing.T) {
+	var got AuditRecord
+	h := AuditMiddleware(NewRedactor([]string{"token"}, handler), func(_ *http.Request, record AuditRecord) { got = record }, AuditOptions{})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for range 3 {
+				_, _ = io.WriteString(w, "data: {\"token\":1}\n\n")
+				if err := http.NewResponseController(w).Flush(); err != nil {
+					t.Error(err)
+				}
+			}
+		}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+	if !rec.Flushed || strings.Count(rec.Body.String(), "token") != 3 {
+		t.Fatalf("flushed=%v body=%s", rec.Flushed, rec.Body.String())
+	}
+	if got.ResponseBody != nil || got.Status != http.StatusOK {
+		t.Fatalf("%+v", got)
+	}
+}
+
+func TestRedactHeaderAndQuery(t *testing.T) {
+	redactor, err := NewFromRules([]Rule{
+		{Expression: "headers.authorization", Handler: ValueHandler(func(v Value) string { return v.Path + ":" + v.Str[:6] })},
+		{Expression: "headers.cookie", Handler: RawHandler(func(Value) string { return `null` })},
+		{Expression: "query.token", Handler: ValueHandler(func(v Value) string { return "<" + v.Path + ">" })},
+		{Expression: "*.password", Handler: ValueHandler(func(Value) string { return "REDACTED" })},
+		{Expression: "headers.x-key?(regex=^sk_)", Handler: ValueHandler(func(Value) string { return "KEY" })},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Authorization": {"Bearer abc"}, "Cookie": {"a=1", "b=2"}, "Accept": {"*/*"}, "X-Key": {"sk_1", "pk_1"}}
+	got := redactor.RedactHeader(header)
+	want := http.Header{"Authorization": {"headers.authorization:Bearer"}, "Cookie": {"null", "null"}, "Accept": {"*/*"}, "X-Key": {"KEY", "pk_1"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal(got)
+	}
+	if header.Get("Authorization") != "Bearer abc" {
+		t.Fatal("original header changed")
+	}
+
+	values := url.Values{"token": {"t1", "t2"}, "password": {"p"}, "q": {"x"}}
+	gotValues := redactor.RedactValues(values)
+	wantValues := url.Values{"token": {"<query.token>", "<query.token>"}, "password": {"REDACTED"}, "q": {"x"}}
+	if fmt.Sprint(gotValues) != fmt.Sprint(wantValues) {
+		t.Fatal(gotValues)
+	}
+	if values.Get("token") != "t1" {
+		t.Fatal("original values changed")
+	}
+
+	for input, want := range map[string]string{
+		"https://h/p?z=1&token=abc&a=%20b#frag": "https://h/p?z=1&token=%3Cquery.token%3E&a=%20b#frag",
+		"/p?password=1&password=2":              "/p?password=REDACTED&password=REDACTED",
+		"/p?q=1":                                "/p?q=1",
+		"/a b?x=1":                              "/a b?x=1",
+		"/a b/%7e?token=1&x=%7e#f?token=2":      "/a b/%7e?token=%3Cquery.token%3E&x=%7e#f?token=2",
+		"/p":                                    "/p",
+		"::not a url":                           "::not a url",
+	} {
+		if got := redactor.RedactURL(input); got != want {
+			t.Fatalf("%s: got %s want %s", input, got, want)
+		}
+	}
+}
+
+func TestAuditMiddlewareHeaders(t *testing.T) {
+	redactor := NewRedactor([]string{"headers.authorization", "headers.set-cookie", "query.token", "password"}, handler)
+	var got AuditRecord
+	h := AuditMiddleware(redactor, func(_ *http.Request, record AuditRecord) { got = record }, AuditOptions{})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Set-Cookie", "session=1")
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	req := httptest.NewRequest("GET", "/me?token=abc", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.URL != "/me?token=REDACTED" || got.RequestHeader.Get("Authorization") != "REDACTED" ||
+		got.ResponseHeader.Get("Set-Cookie") != "REDACTED" || req.Header.Get("Authorization") != "Bearer abc" {
+		t.Fatalf("%+v", got)
+	}
+}
+
+func TestAuditMiddlewarePartialBody(t *testing.T) {
+	redactor := NewRedactor([]string{"password"}, handler)
+	tests := []struct {
+		name          string
+		read          int
+		responseBody  string
+		wantTruncated bool
+		wantResponse  string
+	}{
+		{name: "partly read request", read: 25, wantTruncated: true},
+		{name: "unread request", read: 0},
+		{name: "decoded request", read: len(`{"a":1,"password":"secret","b":2}`)},
+		{name: "invalid response", read: -1, responseBody: `{"a":1,"password":"secret`},
+		{name: "whole request", read: -1, responseBody: `{"password":"x"}`, wantResponse: `{"password":"REDACTED"}`},
+	}
+	body := `{"a":1,"password":"secret","b":2}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got AuditRecord
+			h := AuditMiddleware(redactor, func(_ *http.Request, record AuditRecord) { got = record }, AuditOptions{})(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if tt.read < 0 {
+						_, _ = io.ReadAll(r.Body)
+					} else {
+						_, _ = io.ReadFull(r.Body, make([]byte, tt.read))
+					}
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = io.WriteString(w, tt.responseBody)
+				}))
+			req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if strings.Contains(string(got.RequestBody)+string(got.ResponseBody), "secret") {
+				t.Fatalf("secret leaked: %+v", got)
+			}
+			if got.RequestBodyTruncated != tt.wantTruncated || string(got.ResponseBody) != tt.wantResponse {
+				t.Fatalf("%+v", got)
+			}
+			if (tt.read < 0 || tt.read == len(body)) && string(got.RequestBody) != `{"a":1,"password":"REDACTED","b":2}` {
+				t.Fatalf("%s", got.RequestBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package jsonredact
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// RedactLines redacts newline-delimited JSON (JSON Lines) read from rd line by line and writes it to w.
+// Lines which are not JSON are written as is, line endings are kept.
+func (r Redactor) RedactLines(w io.Writer, rd io.Reader) error {
+	lines := lineReader{in: bufio.NewReader(rd)}
+	out := bufio.NewWriter(w)
+	var buf []byte
+	for {
+		line, err := lines.next()
+		if len(line) != 0 {
+			buf = r.appendRedactLine(buf[:0], line)
+			if _, werr := out.Write(buf); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return out.Flush()
+		}
+		if err != nil {
+			_ = out.Flush()
+			return err
+		}
+	}
+}
+
+// RedactLinesParallel is like RedactLines but redacts up to workers lines concurrently.
+// Lines are written in the order they are read, handlers must be safe for concurrent use.
+// On a write error it returns without waiting for rd, a pending Read of rd is left to finish in the background.
+func (r Redactor) RedactLinesParallel(w io.Writer, rd io.Reader, workers int) error {
+	if workers <= 1 {
+		return r.RedactLines(w, rd)
+	}
+	type job struct {
+		line   []byte
+		result chan []byte
+	}
+	jobs := make(chan job)
+	ordered := make(chan job, workers*2)
+	done := make(chan struct{})
+	var readErr error
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+		lines := lineReader{in: bufio.NewReader(rd)}
+		for {
+			line, err := lines.next()
+			if len(line) != 0 {
+				j := job{line: bytes.Clone(line), result: make(chan []byte, 1)}
+				select {
+				case ordered <- j:
+				case <-done:
+					return
+				}
+				select {
+				case jobs <- j:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+		}
+	}()
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case j, ok := <-jobs:
+					if !ok {
+						return
+					}
+					j.result <- r.appendRedactLine(nil, j.line)
+				case <-done:
+					// the reader may be blocked in rd.Read and never close jobs
+					return
+				}
+			}
+		}()
+	}
+	defer wg.Wait()
+	out := bufio.NewWriter(w)
+	for j := range ordered {
+		if _, err := out.Write(<-j.result); err != nil {
+			close(done)
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// appendRedactLine appends redacted line to dst keeping its line ending.
+func (r Redactor) appendRedactLine(dst, line []byte) []byte {
+	content := bytes.TrimSuffix(line, []byte{'\n'})
+	content = bytes.TrimSuffix(content, []byte{'\r'})
+	dst = r.AppendRedact(dst, content)
+	return append(dst, line[len(content):]...)
+}
+
+// lineReader reads lines of any length reusing its buffer.
+type lineReader struct {
+	in  *bufio.Reader
+	buf []byte
+}
+
+// next returns the next line including '\n', the line is valid until the next call.
+func (l *lineReader) next() ([]byte, error) {
+	l.buf = l.buf[:0]
+	for {
+		chunk, err := l.in.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			l.buf = append(l.buf, chunk...)
+			continue
+		}
+		if len(l.buf) == 0 {
+			return chunk, err
+		}
+		l.buf = append(l.buf, chunk...)
+		return l.buf, err
+	}
+}
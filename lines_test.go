@@ -0,0 +1,97 @@
+package jsonredact
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestRedactLines(t *testing.T) {
+	input := "{\"a\":1,\"b\":2}\n" +
+		"not json\n" +
+		"\n" +
+		"{\"a\":{\"x\":1}}\r\n" +
+		"[1,2]\n" +
+		"{\"b\":\"" + strings.Repeat("x", 2*bufio.MaxScanTokenSize) + "\",\"a\":true}\n" +
+		"{\"a\":\"last line without newline\"}"
+	want := "{\"a\":\"REDACTED\",\"b\":2}\n" +
+		"not json\n" +
+		"\n" +
+		"{\"a\":\"REDACTED\"}\r\n" +
+		"[1,2]\n" +
+		"{\"b\":\"" + strings.Repeat("x", 2*bufio.MaxScanTokenSize) + "\",\"a\":\"REDACTED\"}\n" +
+		"{\"a\":\"REDACTED\"}"
+	redactor := NewRedactor([]string{"a"}, handler)
+	for _, workers := range []int{0, 1, 2, 8} {
+		out := bytes.Buffer{}
+		if err := redactor.RedactLinesParallel(&out, iotest.HalfReader(strings.NewReader(input)), workers); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Fatalf("workers=%d got %s", workers, out.String())
+		}
+	}
+}
+
+func TestRedactLinesParallelOrder(t *testing.T) {
+	builder, want := strings.Builder{}, strings.Builder{}
+	for i := range 10000 {
+		builder.WriteString(`{"id":` + strings.Repeat("1", i%50+1) + `,"name":"n"}` + "\n")
+		want.WriteString(`{"id":` + strings.Repeat("1", i%50+1) + `,"name":"REDACTED"}` + "\n")
+	}
+	redactor := NewRedactor([]string{"name"}, handler)
+	out := bytes.Buffer{}
+	if err := redactor.RedactLinesParallel(&out, strings.NewReader(builder.String()), 16); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want.String() {
+		t.Fatal("order is not preserved")
+	}
+}
+
+func TestRedactLinesErrors(t *testing.T) {
+	redactor := NewRedactor([]string{"a"}, handler)
+	readErr := errors.New("read")
+	for _, workers := range []int{1, 4} {
+		err := redactor.RedactLinesParallel(&bytes.Buffer{}, iotest.ErrReader(readErr), workers)
+		if !errors.Is(err, readErr) {
+			t.Fatalf("workers=%d want read error, got %v", workers, err)
+		}
+		input := strings.Repeat(`{"a":1}`+"\n", 100000)
+		err = redactor.RedactLinesParallel(errWriter{}, strings.NewReader(input), workers)
+		if !errors.Is(err, errWrite) {
+			t.Fatalf("workers=%d want write error, got %v", workers, err)
+		}
+	}
+}
+
+var errWrite = errors.New("write")
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errWrite }
+
+func TestRedactLinesParallelWriteErrorIdleReader(t *testing.T) {
+	redactor := NewRedactor([]string{"a"}, handler)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		_, _ = io.WriteString(pw, `{"b":"`+strings.Repeat("x", 10<<10)+`"}`+"\n")
+		// the pipe stays idle, the reader blocks in Read
+	}()
+	result := make(chan error, 1)
+	go func() { result <- redactor.RedactLinesParallel(errWriter{}, pr, 4) }()
+	select {
+	case err := <-result:
+		if !errors.Is(err, errWrite) {
+			t.Fatalf("want write error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RedactLinesParallel hangs on an idle reader after a write error")
+	}
+}
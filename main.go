@@ -1,14 +1,33 @@
 package jsonredact
 
 import (
-	"bytes"
+	"fmt"
 	"github.com/tidwall/gjson"
+	"slices"
 	"strconv"
+	"strings"
+	"unsafe"
 )
 
 type Redactor struct {
-	automata node
-	handler  func(string) string
+	automata    node
+	expressions []string   // expression of every rule
+	handlers    []Handler  // handler of every rule
+	detectors   []Detector // detector of every rule, nil for rules without one
+	partial     []bool     // rules replacing only detected substrings
+}
+
+// Rule is an expression and a handler of values it matches.
+type Rule struct {
+	Expression string
+	Handler    Handler
+	// Detector, if set, makes the rule match string values at or under Expression in which it detects sensitive data,
+	// an empty Expression means everywhere. Rules without detectors matching the same value win.
+	Detector Detector
+	// Partial, if set with Detector, replaces only the detected substrings keeping the rest of the string:
+	// Handler receives every substring as a string Value with the path of the whole string, replacements which are
+	// JSON strings are unquoted, other JSON is used as text.
+	Partial bool
 }
 
 /*
@@ -16,9 +35,78 @@ User '.' as separator of objects and arrays.
 Use '#' as wildcard for any key or array index.
 Use '*' to apply right expression to all object keys recursively. (makes redactor walk the whole json)
 User '\' to escape control symbols above.
+Malformed expressions are ignored, use NewRedactorE to detect them. Expressions malformed only as regexes,
+selectors, filters or predicates, e.g. /api/v1 or items[0], match their segments as literal keys.
+Handler receives raw JSON of matched values, its result is written as an escaped JSON string.
 */
 func NewRedactor(expressions []string, handler func(string) string) Redactor {
-	return Redactor{handler: handler, automata: newNDFA(expressions...)}
+	return Redactor{
+		handlers:    repeatHandler(stringHandler(handler), len(expressions)),
+		expressions: slices.Clone(expressions),
+		automata:    newNDFA(expressions...),
+	}
+}
+
+// NewRedactorE is like NewRedactor but returns *ExpressionError for the first malformed expression.
+func NewRedactorE(expressions []string, handler func(string) string) (Redactor, error) {
+	automata, err := compileNDFA(options{}, expressions...)
+	if err != nil {
+		return Redactor{}, err
+	}
+	return Redactor{
+		handlers:    repeatHandler(stringHandler(handler), len(expressions)),
+		expressions: slices.Clone(expressions),
+		automata:    automata,
+	}, nil
+}
+
+// New is like NewRedactorE but the handler receives the type, the decoded value and the path of matched values.
+// Options change how keys are matched, e.g. CaseInsensitive.
+func New(expressions []string, handler Handler, opts ...Option) (Redactor, error) {
+	rules := make([]Rule, 0, len(expressions))
+	for _, e := range expressions {
+		rules = append(rules, Rule{Expression: e, Handler: handler})
+	}
+	return NewFromRules(rules, opts...)
+}
+
+// NewFromRules compiles all rules into one automata, so a single pass applies the handler of the matching rule.
+// When several rules match the same value the one that comes first in rules wins.
+func NewFromRules(rules []Rule, opts ...Option) (Redactor, error) {
+	expressions := make([]string, 0, len(rules))
+	handlers := make([]Handler, 0, len(rules))
+	detectors := make([]Detector, 0, len(rules))
+	soft := make([]bool, 0, len(rules))
+	partial := make([]bool, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Handler == nil {
+			return Redactor{}, fmt.Errorf("jsonredact: rule %d %q: nil handler", i, rule.Expression)
+		}
+		expressions = append(expressions, rule.Expression)
+		handlers = append(handlers, rule.Handler)
+		detectors = append(detectors, rule.Detector)
+		soft = append(soft, rule.Detector != nil)
+		partial = append(partial, rule.Partial)
+	}
+	automata, err := compileRules(newOptions(opts), expressions, soft)
+	if err != nil {
+		return Redactor{}, err
+	}
+	return Redactor{
+		handlers:    handlers,
+		expressions: expressions,
+		detectors:   detectors,
+		partial:     partial,
+		automata:    automata,
+	}, nil
+}
+
+func repeatHandler(handler Handler, n int) []Handler {
+	handlers := make([]Handler, n)
+	for i := range handlers {
+		handlers[i] = handler
+	}
+	return handlers
 }
 
 func (r Redactor) Redact(json string) string {
@@ -27,73 +115,135 @@ func (r Redactor) Redact(json string) string {
 	}
 	buffer := &lazyBuffer{originalJson: json}
 	r.redact(json, r.automata, buffer, 0)
-	return buffer.String()
+	if !buffer.started {
+		return json
+	}
+	return string(buffer.buf)
 }
 
+// RedactBytes is like Redact for byte slices. When nothing matches json itself is returned.
+func (r Redactor) RedactBytes(json []byte) []byte {
+	if len(r.automata.states) == 0 {
+		return json
+	}
+	buffer := &lazyBuffer{originalJson: unsafeString(json), aliased: true}
+	r.redact(buffer.originalJson, r.automata, buffer, 0)
+	if !buffer.started {
+		return json
+	}
+	return buffer.buf
+}
+
+// AppendRedact appends redacted json to dst and returns the extended buffer.
+// dst must not overlap json.
+func (r Redactor) AppendRedact(dst, json []byte) []byte {
+	if len(r.automata.states) == 0 {
+		return append(dst, json...)
+	}
+	buffer := &lazyBuffer{buf: dst, originalJson: unsafeString(json), aliased: true}
+	r.redact(buffer.originalJson, r.automata, buffer, 0)
+	if !buffer.started {
+		return append(dst, json...)
+	}
+	return buffer.buf
+}
+
+// unsafeString views b as a string without copying, b must not change while the string is used.
+func unsafeString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// lazyBuffer doesn't write anything until the first match,
+// then it copies originalJson up to the match and writes the rest.
 type lazyBuffer struct {
-	buf          *bytes.Buffer
+	buf          []byte
+	started      bool
 	originalJson string
+	aliased      bool        // originalJson is a view of caller's bytes, handlers get copies of values
+	pathPrefix   []string    // keys leading to originalJson if it is a part of a bigger document
+	path         [32]pathKey // keys leading to the current value of the walk
+	deepPath     []pathKey   // keys deeper than path holds
+	depth        int
+	report       *[]Match // collects matches if not nil
 }
 
-func (b *lazyBuffer) WriteByte(c byte) error {
+// start switches to writing, prefix is the length of originalJson written so far.
+func (b *lazyBuffer) start(prefix int) {
+	if b.started {
+		return
+	}
+	b.started = true
 	if b.buf == nil {
-		return nil
+		b.buf = make([]byte, 0, len(b.originalJson))
 	}
-	return b.buf.WriteByte(c)
+	b.buf = append(b.buf, b.originalJson[:prefix]...)
 }
 
-func (b *lazyBuffer) WriteString(s string) (int, error) {
-	if b.buf == nil {
-		return 0, nil
+func (b *lazyBuffer) WriteByte(c byte) error {
+	if !b.started {
+		return nil
 	}
-	return b.buf.WriteString(s)
+	b.buf = append(b.buf, c)
+	return nil
 }
 
-func (b *lazyBuffer) String() string {
-	if b.buf == nil {
-		return b.originalJson
+func (b *lazyBuffer) WriteString(s string) (int, error) {
+	if !b.started {
+		return 0, nil
 	}
-	return b.buf.String()
+	b.buf = append(b.buf, s...)
+	return len(s), nil
 }
 
+// redact walks json found at offset of the original json.
 func (r Redactor) redact(json string, automata node, buf *lazyBuffer, offset int) {
 	root := gjson.Parse(json)
+	if !root.IsObject() && !root.IsArray() {
+		return // scalar root, nothing to walk
+	}
+	if automata.guarded {
+		// only the root automata comes here guarded, nested ones are resolved by the caller
+		automata = automata.resolve(root, make([]*state, 0, len(automata.states)))
+	}
 	if root.IsArray() {
 		_ = buf.WriteByte('[')
 	} else {
 		_ = buf.WriteByte('{')
 	}
 	var index int
+	length := 0
+	if root.IsArray() && automata.needsLength() {
+		root.ForEach(func(_, _ gjson.Result) bool {
+			length++
+			return true
+		})
+	}
 	statesBuf := make([]*state, 0, 16)
 	root.ForEach(func(key, value gjson.Result) bool {
-		keyStr := key.Str
+		var next node
 		if root.IsArray() {
-			keyStr = strconv.Itoa(index)
+			next = automata.nextIndex(index, length, statesBuf)
+		} else {
+			next = automata.next(key.Str, statesBuf)
+		}
+		if next.guarded {
+			next = next.resolve(value, statesBuf)
 		}
 		if index != 0 {
 			_ = buf.WriteByte(',')
 		}
+		path := pathKey{key: key.Str, index: -1}
+		if root.IsArray() {
+			path.index = index
+		}
 		index++
 		_, _ = buf.WriteString(key.Raw)
 		if !root.IsArray() {
 			_ = buf.WriteByte(':')
 		}
-		next := automata.next(keyStr, statesBuf)
-		if next.isTerminal {
-			if buf.buf == nil {
-				buf.buf = bytes.NewBuffer(make([]byte, 0, len(buf.originalJson)))
-				_, _ = buf.WriteString(buf.originalJson[:offset+value.Index])
-			}
-			_ = buf.WriteByte('"')
-			_, _ = buf.WriteString(r.handler(value.Raw))
-			_ = buf.WriteByte('"')
-			return true
-		}
-		if len(next.states) == 0 || (!value.IsObject() && !value.IsArray()) {
-			_, _ = buf.WriteString(value.Raw)
-			return true
-		}
-		r.redact(value.Raw, next, buf, offset+value.Index)
+		buf.push(path)
+		r.redactValue(value, next, buf, offset)
+		buf.pop()
 		return true
 	})
 	if root.IsArray() {
@@ -102,3 +252,118 @@ func (r Redactor) redact(json string, automata node, buf *lazyBuffer, offset int
 		_ = buf.WriteByte('}')
 	}
 }
+
+// redactValue writes value of json at offset of the original json, the key of value is on top of the path of buf.
+func (r Redactor) redactValue(value gjson.Result, next node, buf *lazyBuffer, offset int) {
+	if next.isTerminal {
+		buf.start(offset + value.Index)
+		r.replace(buf, next.rule, r.handlers[next.rule], value)
+		return
+	}
+	if next.soft && value.Type == gjson.String {
+		if rule, handler, ok := r.detect(next, value.Str); ok {
+			buf.start(offset + value.Index)
+			r.replace(buf, rule, handler, value)
+			return
+		}
+	}
+	if len(next.states) == 0 || (!value.IsObject() && !value.IsArray()) {
+		_, _ = buf.WriteString(value.Raw)
+		return
+	}
+	r.redact(value.Raw, next, buf, offset+value.Index)
+}
+
+// detect returns the first rule whose detector finds sensitive data in the string value at soft terminal states of n
+// and its handler.
+func (r Redactor) detect(n node, str string) (int, Handler, bool) {
+	rule, ok := 0, false
+	var found [][2]int
+	for _, s := range n.states {
+		if !s.soft || ok && s.rule > rule {
+			continue
+		}
+		if detected := r.detectors[s.rule].Detect(str); detected != nil {
+			rule, ok, found = s.rule, true, detected
+		}
+	}
+	if !ok {
+		return 0, nil, false
+	}
+	if r.partial[rule] {
+		return rule, partialHandler{handler: r.handlers[rule], found: found}, true
+	}
+	return rule, r.handlers[rule], true
+}
+
+// replace writes replacement of value by handler of rule, the path of buf leads to value.
+func (r Redactor) replace(buf *lazyBuffer, rule int, handler Handler, value gjson.Result) {
+	keys := buf.keys
+	if buf.report != nil {
+		found := keys()
+		keys = func() []string { return found }
+		*buf.report = append(*buf.report, Match{
+			Path:       pathString(found),
+			Rule:       rule,
+			Expression: r.expressions[rule],
+			Type:       typeOf(value),
+		})
+	}
+	v := handlerValue(handler, value, keys)
+	if buf.aliased {
+		v.Raw, v.Str = strings.Clone(v.Raw), strings.Clone(v.Str)
+	}
+	buf.buf = handler.appendReplacement(buf.buf, v)
+}
+
+// handlerValue builds the Value handler receives, path is not called for handlers of NewRedactor which don't get it.
+func handlerValue(handler Handler, value gjson.Result, path func() []string) Value {
+	if _, ok := handler.(stringHandler); ok {
+		// handlers of NewRedactor never see the path, don't look it up
+		return Value{Raw: value.Raw}
+	}
+	return newValue(value, pathString(path()))
+}
+
+// pathKey is a key of the walk, converted to a string only for paths of matched values.
+type pathKey struct {
+	key   string
+	index int // index of an array element, -1 for object keys
+}
+
+// push adds key to the path, the first keys are kept in place so tracking paths doesn't allocate.
+func (b *lazyBuffer) push(key pathKey) {
+	if b.depth < len(b.path) {
+		b.path[b.depth] = key
+	} else {
+		b.deepPath = append(b.deepPath, key)
+	}
+	b.depth++
+}
+
+func (b *lazyBuffer) pop() {
+	b.depth--
+	if b.depth >= len(b.path) {
+		b.deepPath = b.deepPath[:len(b.deepPath)-1]
+	}
+}
+
+// keys returns keys leading to the current value.
+func (b *lazyBuffer) keys() []string {
+	keys := make([]string, 0, len(b.pathPrefix)+b.depth)
+	keys = append(keys, b.pathPrefix...)
+	for i := 0; i < b.depth; i++ {
+		var k pathKey
+		if i < len(b.path) {
+			k = b.path[i]
+		} else {
+			k = b.deepPath[i-len(b.path)]
+		}
+		if k.index >= 0 {
+			keys = append(keys, strconv.Itoa(k.index))
+		} else {
+			keys = append(keys, k.key)
+		}
+	}
+	return keys
+}
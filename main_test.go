@@ -3,6 +3,7 @@ package jsonredact
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -232,18 +233,663 @@ func TestRedact(t *testing.T) {
 				keys: []string{`a.*.name`}},
 			want: `{"a":{"b":{"name":"REDACTED","c":{"a":{"b":[[{"name":"REDACTED"},[{"name":"REDACTED"}]]],"name":"REDACTED"}}}},"name":"b"}`,
 		},
+		{
+			name: "glob/keys containing",
+			args: args{json: `{"password":1,"userPassword":2,"password_hash":3,"pass":4,"x":{"oldpassword":5}}`, keys: []string{`*pass*`, `x.*password`}},
+			want: `{"password":"REDACTED","userPassword":2,"password_hash":"REDACTED","pass":"REDACTED","x":{"oldpassword":"REDACTED"}}`,
+		},
+		{
+			name: "glob/prefix and suffix",
+			args: args{json: `{"a_x_b":1,"ab":2,"a_b":3,"axb_":4,"a":{"ab":5}}`, keys: []string{`a*x*b`, `a.a*b`}},
+			want: `{"a_x_b":"REDACTED","ab":2,"a_b":3,"axb_":4,"a":{"ab":"REDACTED"}}`,
+		},
+		{
+			name: "glob/escaped star",
+			args: args{json: `{"a*":1,"ab":2}`, keys: []string{`a\*`}},
+			want: `{"a*":"REDACTED","ab":2}`,
+		},
+		{
+			name: "regex/keys",
+			args: args{json: `{"Password":1,"userPassword":2,"passcode":3,"x":{"pass":4,"a.b":5}}`, keys: []string{`/(?i)^pass/`, `x./^a\.b$/`}},
+			want: `{"Password":"REDACTED","userPassword":2,"passcode":"REDACTED","x":{"pass":4,"a.b":"REDACTED"}}`,
+		},
+		{
+			name: "regex/recursive and in the middle",
+			args: args{json: `{"a":{"items1":{"id":1},"items2":{"id":2},"other":{"id":3}},"id":4}`, keys: []string{`*./^items\d$/.id`}},
+			want: `{"a":{"items1":{"id":"REDACTED"},"items2":{"id":"REDACTED"},"other":{"id":3}},"id":4}`,
+		},
+		{
+			name: "index/slice",
+			args: args{json: `{"a":[1,2,3,4,5],"b":{"0":1,"1":2}}`, keys: []string{`a.[1:3]`, `b.[0:2]`}},
+			want: `{"a":[1,"REDACTED","REDACTED",4,5],"b":{"0":1,"1":2}}`,
+		},
+		{
+			name: "index/open slices",
+			args: args{json: `{"a":[1,2,3,4,5],"b":[1,2,3,4,5],"c":[1,2,3]}`, keys: []string{`a.[:2]`, `b.[3:]`, `c.[:]`}},
+			want: `{"a":["REDACTED","REDACTED",3,4,5],"b":[1,2,3,"REDACTED","REDACTED"],"c":["REDACTED","REDACTED","REDACTED"]}`,
+		},
+		{
+			name: "index/negative",
+			args: args{json: `{"a":[1,2,3,4,5],"b":[1,2,3,4,5],"c":[1,2,3,4,5]}`, keys: []string{`a.[-1]`, `b.[-2]`, `c.[1:-1]`}},
+			want: `{"a":[1,2,3,4,"REDACTED"],"b":[1,2,3,"REDACTED",5],"c":[1,"REDACTED","REDACTED","REDACTED",5]}`,
+		},
+		{
+			name: "index/list",
+			args: args{json: `{"a":[0,1,2,3,4,5,6,7,8]}`, keys: []string{`a.[1,4,7,-1,20]`}},
+			want: `{"a":[0,"REDACTED",2,3,"REDACTED",5,6,"REDACTED","REDACTED"]}`,
+		},
+		{
+			name: "index/nested and recursive",
+			args: args{json: `{"trail":[{"who":"a"},{"who":"b"},{"who":"c"}],"x":{"trail":[{"who":"d"},{"who":"e"}]}}`, keys: []string{`*.trail.[1:].who`}},
+			want: `{"trail":[{"who":"a"},{"who":"REDACTED"},{"who":"REDACTED"}],"x":{"trail":[{"who":"d"},{"who":"REDACTED"}]}}`,
+		},
+		{
+			name: "index/root array and out of range",
+			args: args{json: `[[1,2],[3,4],[5]]`, keys: []string{`[-1].[0]`, `[0].[-3]`}},
+			want: `[[1,2],[3,4],["REDACTED"]]`,
+		},
+		{
+			name: "index/escaped bracket is a key",
+			args: args{json: `{"[0]":1,"a":[1]}`, keys: []string{`\[0]`}},
+			want: `{"[0]":"REDACTED","a":[1]}`,
+		},
+		{
+			name: "predicate/len",
+			args: args{json: `{"a":{"card_number":"4111111111111111"},"b":{"card_number":"1234"},"c":{"card_number":41111111111111111},"d":{"card_number":[1,2,3]}}`,
+				keys: []string{`*.card_number?(len>12)`, `d.card_number?(len==3)`}},
+			want: `{"a":{"card_number":"REDACTED"},"b":{"card_number":"1234"},"c":{"card_number":"REDACTED"},"d":{"card_number":"REDACTED"}}`,
+		},
+		{
+			name: "predicate/len operators",
+			args: args{json: `{"a":"ab","b":"ab","c":"ab","d":"ab","e":"ab","f":"яя"}`, keys: []string{`a?(len>=2)`, `b?(len<=1)`, `c?(len!=2)`, `d?(len<3)`, `e?(len>2)`, `f?(len==2)`}},
+			want: `{"a":"REDACTED","b":"ab","c":"ab","d":"REDACTED","e":"ab","f":"REDACTED"}`,
+		},
+		{
+			name: "predicate/regex",
+			args: args{json: `{"users":[{"ssn":"123-45-6789"},{"ssn":"n/a"},{"ssn":12345}]}`, keys: []string{`users.#.ssn?(regex=^\d{3}-\d{2})`, `users.#.ssn?(regex=^\d+$)`}},
+			want: `{"users":[{"ssn":"REDACTED"},{"ssn":"n/a"},{"ssn":"REDACTED"}]}`,
+		},
+		{
+			name: "predicate/type",
+			args: args{json: `{"a":{"x":1},"b":"s","c":[1],"d":null,"e":true,"f":2}`, keys: []string{`#?(type=object)`, `b?(type=string)`, `c?(type=array)`, `d?(type=null)`, `e?(type=bool)`, `f?(type=string)`}},
+			want: `{"a":"REDACTED","b":"REDACTED","c":"REDACTED","d":"REDACTED","e":"REDACTED","f":2}`,
+		},
+		{
+			name: "predicate/failed predicate keeps walking",
+			args: args{json: `{"a":{"b":1,"c":{"b":"xx"}}}`, keys: []string{`a?(type=string)`, `*.b?(len>1)`}},
+			want: `{"a":{"b":1,"c":{"b":"REDACTED"}}}`,
+		},
+		{
+			name: "predicate/with regex key and index",
+			args: args{json: `{"ab":"long value","ac":"x","l":["xx","x"]}`, keys: []string{`/^a/?(len>1)`, `l.[0:2]?(len>1)`}},
+			want: `{"ab":"REDACTED","ac":"x","l":["REDACTED","x"]}`,
+		},
+		{
+			name: "filter/array of events",
+			args: args{json: `{"events":[{"type":"password_reset","payload":{"token":"t1","user":"u1"}},{"type":"login","payload":{"token":"t2"}}]}`,
+				keys: []string{`events.#[type=="password_reset"].payload.token`}},
+			want: `{"events":[{"type":"password_reset","payload":{"token":"REDACTED","user":"u1"}},{"type":"login","payload":{"token":"t2"}}]}`,
+		},
+		{
+			name: "filter/root",
+			args: args{json: `{"type":"password_reset","payload":{"token":"t1"}}`, keys: []string{`[type=="password_reset"].payload.token`, `[type=="login"].payload`}},
+			want: `{"type":"password_reset","payload":{"token":"REDACTED"}}`,
+		},
+		{
+			name: "filter/operators and literals",
+			args: args{json: `[{"n":1,"b":true,"s":"x]\"y","m":{"k":null},"v":1},{"n":1.0,"b":false,"s":"z","v":2},{"v":3}]`,
+				keys: []string{`#[n==1][b==true].v`, `#[s=="x]\"y"][m.k==null].s`, `#[b!=true].v`}},
+			want: `[{"n":1,"b":true,"s":"REDACTED","m":{"k":null},"v":"REDACTED"},{"n":1.0,"b":false,"s":"z","v":"REDACTED"},{"v":"REDACTED"}]`,
+		},
+		{
+			name: "filter/terminal and recursive",
+			args: args{json: `{"a":{"kind":"secret","x":1},"b":{"kind":"public"},"c":[{"kind":"secret","d":{"token":1}}]}`,
+				keys: []string{`*.#[kind=="secret"]`}},
+			want: `{"a":"REDACTED","b":{"kind":"public"},"c":["REDACTED"]}`,
+		},
+		{
+			name: "filter/before recursive",
+			args: args{json: `[{"t":"x","token":1,"a":{"token":2,"b":{"token":3}}},{"t":"y","token":4,"a":{"token":5}}]`,
+				keys: []string{`#[t=="x"].*.token`}},
+			want: `[{"t":"x","token":"REDACTED","a":{"token":"REDACTED","b":{"token":"REDACTED"}}},{"t":"y","token":4,"a":{"token":5}}]`,
+		},
+		{
+			name: "filter/with predicate",
+			args: args{json: `[{"t":"x","token":"long"},{"t":"x","token":"s"},{"t":"y","token":"long"}]`,
+				keys: []string{`#[t=="x"].token?(len>2)`}},
+			want: `[{"t":"x","token":"REDACTED"},{"t":"x","token":"s"},{"t":"y","token":"long"}]`,
+		},
+		{
+			name: "regex/slash",
+			args: args{json: `{"a/b":1,"ab":2}`, keys: []string{`/^a\/b$/`}},
+			want: `{"a/b":"REDACTED","ab":2}`,
+		},
+		{
+			name: "literal/slashes",
+			args: args{json: `{"/api/v1":{"token":1},"paths":{"/users":2,"x":3}}`, keys: []string{"/api/v1.token", "paths./users"}},
+			want: `{"/api/v1":{"token":"REDACTED"},"paths":{"/users":"REDACTED","x":3}}`,
+		},
+		{
+			name: "literal/brackets",
+			args: args{json: `{"items[0]":1,"items":[2],"m":{"a[b=1]":3}}`, keys: []string{"items[0]", "m.a[b=1]"}},
+			want: `{"items[0]":"REDACTED","items":[2],"m":{"a[b=1]":"REDACTED"}}`,
+		},
+		{
+			name: "literal/question mark and parenthesis",
+			args: args{json: `{"a?(x)":1,"a":2,"b":{"c?(size>1)":3}}`, keys: []string{"a?(x)", "b.c?(size>1)"}},
+			want: `{"a?(x)":"REDACTED","a":2,"b":{"c?(size>1)":"REDACTED"}}`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			redactor := NewRedactor(tt.args.keys, handler)
 			fmt.Println(redactor.automata)
-			if indentIfJSONString(tt.want) != indentIfJSONString(redactor.Redact(tt.args.json)) {
+			got := redactor.Redact(tt.args.json)
+			if indentIfJSONString(tt.want) != indentIfJSONString(got) {
 				t.Fail()
 			}
+			if gotBytes := redactor.RedactBytes([]byte(tt.args.json)); string(gotBytes) != got {
+				t.Fatalf("RedactBytes=%s Redact=%s", gotBytes, got)
+			}
+			if gotBytes := redactor.AppendRedact([]byte("prefix"), []byte(tt.args.json)); string(gotBytes) != "prefix"+got {
+				t.Fatalf("AppendRedact=%s Redact=%s", gotBytes, got)
+			}
 		})
 	}
 }
 
+func TestNewRedactorE(t *testing.T) {
+	tests := []struct {
+		name        string
+		expressions []string
+		want        *ExpressionError
+	}{
+		{name: "valid", expressions: []string{"a", "a.b", `a\.b`, "*.a", "a.*.#", `\*`, `a\\`, "*pass*", "a./(?i)^pass/.b", `/a\/b/`, "a/b", "(?i)a.(?i)*b*.(?i)/c/", `\(?i)`, "a.[0:3].b", "[-1]", "a.[1,4,-7:]", `a\[0]`,
+			"a?(len>1)", "/a/?(type=string)", "[0]?(regex=^a)", "a?b", `a\?(b)`,
+			`#[type=="x"].a`, `[a!=1][b=="]"].c`, `/a/[b==null].[0][c==true]?(len>1)`}},
+		{name: "empty expression", expressions: []string{"a", ""},
+			want: &ExpressionError{Index: 1, Expression: "", Column: 1, Reason: "empty expression"}},
+		{name: "empty segment", expressions: []string{"a..b"},
+			want: &ExpressionError{Index: 0, Expression: "a..b", Column: 3, Reason: "empty segment"}},
+		{name: "leading point", expressions: []string{".a"},
+			want: &ExpressionError{Index: 0, Expression: ".a", Column: 1, Reason: "empty segment"}},
+		{name: "trailing point", expressions: []string{"a.b."},
+			want: &ExpressionError{Index: 0, Expression: "a.b.", Column: 4, Reason: "empty segment"}},
+		{name: "dangling escape", expressions: []string{"b", "c", `a\`},
+			want: &ExpressionError{Index: 2, Expression: `a\`, Column: 2, Reason: "dangling escape"}},
+		{name: "trailing star", expressions: []string{"a.*"},
+			want: &ExpressionError{Index: 0, Expression: "a.*", Column: 3, Reason: "'*' must be followed by a segment"}},
+		{name: "double star", expressions: []string{"*.*.a"},
+			want: &ExpressionError{Index: 0, Expression: "*.*.a", Column: 3, Reason: "'*' must not be followed by '*'"}},
+		{name: "unterminated regex", expressions: []string{"a./b"},
+			want: &ExpressionError{Index: 0, Expression: "a./b", Column: 3, Reason: "unterminated regex"}},
+		{name: "regex not ending segment", expressions: []string{"/a/b.c"},
+			want: &ExpressionError{Index: 0, Expression: "/a/b.c", Column: 4, Reason: "regex must end its segment"}},
+		{name: "invalid regex", expressions: []string{"a./(/"},
+			want: &ExpressionError{Index: 0, Expression: "a./(/", Column: 3, Reason: "invalid regex: error parsing regexp: missing closing ): `(`"}},
+		{name: "only case-insensitive prefix", expressions: []string{"a.(?i)"},
+			want: &ExpressionError{Index: 0, Expression: "a.(?i)", Column: 6, Reason: "empty segment"}},
+		{name: "unterminated case-insensitive regex", expressions: []string{"(?i)/a"},
+			want: &ExpressionError{Index: 0, Expression: "(?i)/a", Column: 5, Reason: "unterminated regex"}},
+		{name: "unterminated index selector", expressions: []string{"a.[1"},
+			want: &ExpressionError{Index: 0, Expression: "a.[1", Column: 3, Reason: "unterminated '['"}},
+		{name: "index selector not ending segment", expressions: []string{"a.[1]b"},
+			want: &ExpressionError{Index: 0, Expression: "a.[1]b", Column: 6, Reason: "index selector must end its segment"}},
+		{name: "invalid index", expressions: []string{"a.[1,x:2]"},
+			want: &ExpressionError{Index: 0, Expression: "a.[1,x:2]", Column: 6, Reason: `invalid index "x:2"`}},
+		{name: "empty index selector", expressions: []string{"[]"},
+			want: &ExpressionError{Index: 0, Expression: "[]", Column: 2, Reason: `invalid index ""`}},
+		{name: "predicate not at the end", expressions: []string{"a?(len>1).b"},
+			want: &ExpressionError{Index: 0, Expression: "a?(len>1).b", Column: 2, Reason: "predicate must end the expression with ')'"}},
+		{name: "unknown predicate", expressions: []string{"a?(size>1)"},
+			want: &ExpressionError{Index: 0, Expression: "a?(size>1)", Column: 2, Reason: `invalid predicate: unknown predicate "size>1"`}},
+		{name: "invalid length", expressions: []string{"a?(len>x)"},
+			want: &ExpressionError{Index: 0, Expression: "a?(len>x)", Column: 2, Reason: `invalid predicate: invalid length "x"`}},
+		{name: "unknown type", expressions: []string{"a?(type=str)"},
+			want: &ExpressionError{Index: 0, Expression: "a?(type=str)", Column: 2, Reason: `invalid predicate: unknown type "str"`}},
+		{name: "predicate without key", expressions: []string{"a.?(len>1)"},
+			want: &ExpressionError{Index: 0, Expression: "a.?(len>1)", Column: 3, Reason: "empty segment"}},
+		{name: "invalid filter", expressions: []string{"a[b=1]"},
+			want: &ExpressionError{Index: 0, Expression: "a[b=1]", Column: 2, Reason: `invalid filter: want field==value or field!=value, got "b=1"`}},
+		{name: "invalid filter value", expressions: []string{"#[b==x]"},
+			want: &ExpressionError{Index: 0, Expression: "#[b==x]", Column: 2, Reason: `invalid filter: invalid value x, want a JSON string, number, boolean or null`}},
+		{name: "filter not ending segment", expressions: []string{"#[b==1]c"},
+			want: &ExpressionError{Index: 0, Expression: "#[b==1]c", Column: 8, Reason: "filter must end its segment"}},
+		{name: "only filters", expressions: []string{"[b==1]"},
+			want: &ExpressionError{Index: 0, Expression: "[b==1]", Column: 1, Reason: "expression has only filters"}},
+		{name: "filter after star", expressions: []string{"*.[b==1].c"},
+			want: &ExpressionError{Index: 0, Expression: "*.[b==1].c", Column: 3, Reason: "'*' must not be followed by a filter"}},
+		{name: "trailing point after regex", expressions: []string{"/a/."},
+			want: &ExpressionError{Index: 0, Expression: "/a/.", Column: 4, Reason: "empty segment"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRedactorE(tt.expressions, handler)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var exprErr *ExpressionError
+			if !errors.As(err, &exprErr) {
+				t.Fatalf("want *ExpressionError, got %v", err)
+			}
+			if *exprErr != *tt.want {
+				t.Fatalf("want %+v, got %+v", tt.want, exprErr)
+			}
+		})
+	}
+}
+
+func TestNewRedactorIgnoresMalformed(t *testing.T) {
+	redactor := NewRedactor([]string{"a.*", "a..b", `a\`, "b"}, handler)
+	if got := redactor.Redact(`{"a":{"b":1},"b":2}`); got != `{"a":{"b":1},"b":"REDACTED"}` {
+		t.Fatal(got)
+	}
+}
+
+func TestValueHandler(t *testing.T) {
+	var got []Value
+	redactor, err := New([]string{"*.v", `a\.b.#`}, ValueHandler(func(v Value) string {
+		got = append(got, v)
+		return v.Type.String()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := redactor.Redact(`{"v":"a\"b","x":[{"v":1.5},{"v":true},{"v":null}],"y":{"v":{"k":[]}},"a.b":[[1]]}`)
+	want := `{"v":"string","x":[{"v":"number"},{"v":"bool"},{"v":"null"}],"y":{"v":"object"},"a.b":["array"]}`
+	if output != want {
+		t.Fatal(output)
+	}
+	wantValues := []Value{
+		{Type: TypeString, Raw: `"a\"b"`, Str: `a"b`, Path: "v"},
+		{Type: TypeNumber, Raw: `1.5`, Str: `1.5`, Path: "x.0.v"},
+		{Type: TypeBool, Raw: `true`, Str: `true`, Path: "x.1.v"},
+		{Type: TypeNull, Raw: `null`, Str: `null`, Path: "x.2.v"},
+		{Type: TypeObject, Raw: `{"k":[]}`, Str: `{"k":[]}`, Path: "y.v"},
+		{Type: TypeArray, Raw: `[1]`, Str: `[1]`, Path: `a\.b.0`},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(wantValues) {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestNewValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Value
+	}{
+		{raw: `"a\"b"`, want: Value{Type: TypeString, Raw: `"a\"b"`, Str: `a"b`}},
+		{raw: `1.5`, want: Value{Type: TypeNumber, Raw: `1.5`, Str: `1.5`}},
+		{raw: `[1]`, want: Value{Type: TypeArray, Raw: `[1]`, Str: `[1]`}},
+		{raw: `{"a":1}`, want: Value{Type: TypeObject, Raw: `{"a":1}`, Str: `{"a":1}`}},
+		{raw: `null`, want: Value{Type: TypeNull, Raw: `null`, Str: `null`}},
+	}
+	for _, tt := range tests {
+		if got := NewValue(tt.raw); got != tt.want {
+			t.Fatalf("%s: got %+v", tt.raw, got)
+		}
+	}
+}
+
+func TestValueHandlerDeepPath(t *testing.T) {
+	redactor, err := New([]string{"*.v"}, ValueHandler(func(v Value) string { return v.Path }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, path := `{"v":1}`, "v"
+	for i := 0; i < 50; i++ {
+		json, path = `[0,{"k":`+json+`,"v":2}]`, "1.k."+path
+	}
+	want := strings.Replace(json, `"v":1`, `"v":"`+path+`"`, 1)
+	for i := 0; i < 50; i++ {
+		// v next to the i-th k from the top
+		want = strings.Replace(want, `"v":2}`, `"v":"`+strings.Repeat("1.k.", 49-i)+`1.v"}`, 1)
+	}
+	if got := redactor.Redact(json); got != want {
+		t.Fatal(got)
+	}
+	assertStreamEqualsRedact(t, redactor, json)
+}
+
+func TestRawHandler(t *testing.T) {
+	zero := RawHandler(func(v Value) string {
+		switch v.Type {
+		case TypeNumber:
+			return `0`
+		case TypeObject:
+			return `{}`
+		case TypeArray:
+			return `[]`
+		case TypeString:
+			return `"x\"y"`
+		}
+		return `null`
+	})
+	tests := []struct {
+		name    string
+		handler Handler
+		json    string
+		want    string
+	}{
+		{
+			name:    "raw/type preserving",
+			handler: zero,
+			json:    `{"a":{"n":12.5,"o":{"k":1},"l":[1,2],"s":"secret","b":true}}`,
+			want:    `{"a":{"n":0,"o":{},"l":[],"s":"x\"y","b":null}}`,
+		},
+		{
+			name:    "raw/validated",
+			handler: RawHandler(func(v Value) string { return `{"broken"` }).Validated(`null`),
+			json:    `{"a":{"n":12.5,"s":"secret"}}`,
+			want:    `{"a":{"n":null,"s":null}}`,
+		},
+		{
+			name:    "raw/validated valid",
+			handler: RawHandler(func(v Value) string { return v.Raw }).Validated(`null`),
+			json:    `{"a":{"n":12.5,"s":"secret"}}`,
+			want:    `{"a":{"n":12.5,"s":"secret"}}`,
+		},
+		{
+			name:    "value/escaped",
+			handler: ValueHandler(func(v Value) string { return "say \"" + v.Str + "\"\n\\\x01" }),
+			json:    `{"a":{"n":12.5,"s":"secret"}}`,
+			want:    `{"a":{"n":"say \"12.5\"\n\\\u0001","s":"say \"secret\"\n\\\u0001"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor, err := New([]string{"a.#"}, tt.handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redactor.Redact(tt.json); got != tt.want {
+				t.Fatal(got)
+			}
+		})
+	}
+}
+
+func TestHandlerOutputIsValidJSON(t *testing.T) {
+	outputs := []string{
+		``, `"`, `\`, `"secret"`, `\"`, `\\"`, "a\nb\r\tc\b\f", "\x00\x01\x1f\x7f", `{"a":1}`, `</script>`,
+		"\u2028\u2029", "привет", "\xff\xfe", "a\xc3", "\xed\xa0\x80", `\u00`, `\ud800`,
+	}
+	for i := 0; i < 1e3; i++ {
+		b := make([]byte, random.Intn(16))
+		for j := range b {
+			b[j] = byte(random.Intn(256))
+		}
+		outputs = append(outputs, string(b))
+	}
+	docs := []string{
+		`{"a":1,"b":{"a":"x"},"c":[{"a":null}]}`,
+		`[{"a":true},{"a":[1,2]},{"a":{"a":"\"q\""}}]`,
+		`{ "a" : "1" , "z":{ "a" : 2 } }`,
+	}
+	for _, output := range outputs {
+		legacy := NewRedactor([]string{"*.a"}, func(string) string { return output })
+		value, err := New([]string{"*.a"}, ValueHandler(func(Value) string { return output }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range docs {
+			for _, redactor := range []Redactor{legacy, value} {
+				got := redactor.Redact(doc)
+				if !json.Valid([]byte(got)) {
+					t.Fatalf("handler output %q produced invalid json %s", output, got)
+				}
+				var decoded any
+				if err := json.Unmarshal([]byte(got), &decoded); err != nil {
+					t.Fatal(err)
+				}
+				var want string // encoding/json replaces invalid UTF-8 the same way
+				marshaled, _ := json.Marshal(output)
+				_ = json.Unmarshal(marshaled, &want)
+				if v := firstA(decoded); v != want {
+					t.Fatalf("handler output %q decoded as %q", output, v)
+				}
+			}
+		}
+	}
+}
+
+// firstA finds value of the first key 'a' in decoded json.
+func firstA(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if a, ok := v["a"]; ok {
+			return a
+		}
+	case []any:
+		return firstA(v[0])
+	}
+	return nil
+}
+
+func TestNewFromRules(t *testing.T) {
+	placeholder := func(p string) Handler {
+		return ValueHandler(func(Value) string { return p })
+	}
+	tests := []struct {
+		name  string
+		rules []Rule
+		json  string
+		want  string
+	}{
+		{
+			name:  "different handlers",
+			rules: []Rule{{Expression: "*.email", Handler: placeholder("EMAIL")}, {Expression: "*.password", Handler: placeholder("PASSWORD")}},
+			json:  `{"email":"a@b.c","user":{"password":"123","email":"d@e.f"}}`,
+			want:  `{"email":"EMAIL","user":{"password":"PASSWORD","email":"EMAIL"}}`,
+		},
+		{
+			name:  "first rule wins",
+			rules: []Rule{{Expression: "a.b", Handler: placeholder("FIRST")}, {Expression: "*.b", Handler: placeholder("SECOND")}},
+			json:  `{"a":{"b":1},"c":{"b":2}}`,
+			want:  `{"a":{"b":"FIRST"},"c":{"b":"SECOND"}}`,
+		},
+		{
+			name:  "first rule wins, different order",
+			rules: []Rule{{Expression: "*.b", Handler: placeholder("FIRST")}, {Expression: "a.b", Handler: placeholder("SECOND")}},
+			json:  `{"a":{"b":1},"c":{"b":2}}`,
+			want:  `{"a":{"b":"FIRST"},"c":{"b":"FIRST"}}`,
+		},
+		{
+			name:  "general wins over particular",
+			rules: []Rule{{Expression: "a.b", Handler: placeholder("PARTICULAR")}, {Expression: "a", Handler: placeholder("GENERAL")}},
+			json:  `{"a":{"b":1}}`,
+			want:  `{"a":"GENERAL"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor, err := NewFromRules(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redactor.Redact(tt.json); got != tt.want {
+				t.Fatal(got)
+			}
+		})
+	}
+	if _, err := NewFromRules([]Rule{{Expression: "a"}}); err == nil {
+		t.Fatal("want error for nil handler")
+	}
+	var exprErr *ExpressionError
+	if _, err := NewFromRules([]Rule{{Expression: "a", Handler: placeholder("")}, {Expression: "a.*", Handler: placeholder("")}}); !errors.As(err, &exprErr) || exprErr.Index != 1 {
+		t.Fatalf("want expression error of rule 1, got %v", err)
+	}
+}
+
+func TestRedactBytesNoMatchAllocations(t *testing.T) {
+	redactor := NewRedactor([]string{"*.nomatch"}, handler)
+	input := []byte(bigJson)
+	dst := make([]byte, 0, len(input))
+	if allocs := testing.AllocsPerRun(100, func() {
+		if out := redactor.RedactBytes(input); &out[0] != &input[0] {
+			t.Fatal("want original slice")
+		}
+		_ = redactor.AppendRedact(dst, input)
+	}); allocs != 0 {
+		t.Fatalf("want no allocations, got %v", allocs)
+	}
+}
+
+var valueHandler = ValueHandler(func(Value) string { return "REDACTED" })
+
+func TestCaseInsensitive(t *testing.T) {
+	json := `{"Password":1,"PASSWORD":2,"password":3,"user":{"Token":4,"ПАРОЛЬ":5,"\u212Aey":6,"apiKey":7},"(?i)x":8}`
+	tests := []struct {
+		name        string
+		expressions []string
+		opts        []Option
+		want        string
+	}{
+		{
+			name:        "option",
+			expressions: []string{"password", "user.token", "user.пароль", "user.key", "*.*KEY"},
+			opts:        []Option{CaseInsensitive()},
+			want:        `{"Password":"REDACTED","PASSWORD":"REDACTED","password":"REDACTED","user":{"Token":"REDACTED","ПАРОЛЬ":"REDACTED","\u212Aey":"REDACTED","apiKey":"REDACTED"},"(?i)x":8}`,
+		},
+		{
+			name:        "option with regex",
+			expressions: []string{"/^pass/", "#./^tok/"},
+			opts:        []Option{CaseInsensitive()},
+			want:        `{"Password":"REDACTED","PASSWORD":"REDACTED","password":"REDACTED","user":{"Token":"REDACTED","ПАРОЛЬ":5,"\u212Aey":6,"apiKey":7},"(?i)x":8}`,
+		},
+		{
+			name:        "segment",
+			expressions: []string{"(?i)password", "user.(?i)*KEY", "User.(?i)token", `\(?i)x`},
+			want:        `{"Password":"REDACTED","PASSWORD":"REDACTED","password":"REDACTED","user":{"Token":4,"ПАРОЛЬ":5,"\u212Aey":"REDACTED","apiKey":"REDACTED"},"(?i)x":"REDACTED"}`,
+		},
+		{
+			name:        "segment regex",
+			expressions: []string{"(?i)/^pass/", "(?i)user.(?i)/^tok/"},
+			want:        `{"Password":"REDACTED","PASSWORD":"REDACTED","password":"REDACTED","user":{"Token":"REDACTED","ПАРОЛЬ":5,"\u212Aey":6,"apiKey":7},"(?i)x":8}`,
+		},
+		{
+			name:        "case sensitive by default",
+			expressions: []string{"password", "user.token"},
+			want:        `{"Password":1,"PASSWORD":2,"password":"REDACTED","user":{"Token":4,"ПАРОЛЬ":5,"\u212Aey":6,"apiKey":7},"(?i)x":8}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor, err := New(tt.expressions, valueHandler, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redactor.Redact(json); got != tt.want {
+				t.Fatal(got)
+			}
+			assertStreamEqualsRedact(t, redactor, json)
+		})
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	decomposed := strings.NewReplacer("\u00e9", "e\u0301")
+	redactor, err := New([]string{"caf\u00e9", "(?i)*NAÏVE*"}, valueHandler, NormalizeKeys(decomposed.Replace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := `{"caf\u00e9":1,"cafe\u0301":2,"cafe":3,"a_naïve_b":4}`
+	if got := redactor.Redact(json); got != `{"caf\u00e9":"REDACTED","cafe\u0301":"REDACTED","cafe":3,"a_naïve_b":"REDACTED"}` {
+		t.Fatal(got)
+	}
+}
+
+func TestOptionsKeepRegexes(t *testing.T) {
+	tests := []struct {
+		expression string
+		opts       []Option
+		json       string
+		want       string
+	}{
+		{
+			expression: `/(?i)^pass/`,
+			opts:       []Option{NormalizeKeys(strings.ToUpper), CaseInsensitive()},
+			json:       `{"password":1,"PASS":2,"user":3}`,
+			want:       `{"password":"REDACTED","PASS":"REDACTED","user":3}`,
+		},
+		{
+			expression: `/^\D+$/`,
+			opts:       []Option{NormalizeKeys(strings.ToLower)},
+			json:       `{"ABC":1,"123":2}`,
+			want:       `{"ABC":"REDACTED","123":2}`,
+		},
+		{
+			expression: `/^\D+$/`,
+			opts:       []Option{CaseInsensitive()},
+			json:       `{"abc":1,"123":2}`,
+			want:       `{"abc":"REDACTED","123":2}`,
+		},
+		{
+			expression: `(?i)/^a\d$/`,
+			json:       `{"A1":1,"a2":2,"ab":3}`,
+			want:       `{"A1":"REDACTED","a2":"REDACTED","ab":3}`,
+		},
+	}
+	for _, tt := range tests {
+		redactor, err := New([]string{tt.expression}, valueHandler, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactor.Redact(tt.json); got != tt.want {
+			t.Fatalf("%s: got %s", tt.expression, got)
+		}
+	}
+}
+
+func TestCaseInsensitiveNoMatchAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("regexes allocate under the race detector")
+	}
+	redactor, err := New([]string{"*.NoMatch", "*.*nomatch*"}, valueHandler, CaseInsensitive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := []byte(strings.ToUpper(bigJson))
+	if allocs := testing.AllocsPerRun(100, func() {
+		_ = redactor.RedactBytes(input)
+	}); allocs != 0 {
+		t.Fatalf("want no allocations, got %v", allocs)
+	}
+}
+
+func TestKeyMatchersNoMatchAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("regexes allocate under the race detector")
+	}
+	redactor := NewRedactor([]string{"*.*nomatch*", "*./^nomatch/", "*.[1:-1].nomatch"}, handler)
+	input := []byte(bigJson)
+	if allocs := testing.AllocsPerRun(100, func() {
+		_ = redactor.RedactBytes(input)
+	}); allocs != 0 {
+		t.Fatalf("want no allocations, got %v", allocs)
+	}
+}
+
+func TestRedactBytesHandlerGetsCopies(t *testing.T) {
+	var kept []string
+	redactor, err := New([]string{"a"}, ValueHandler(func(v Value) string {
+		kept = append(kept, v.Raw, v.Str)
+		return ""
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := []byte(`{"a":"secret"}`)
+	_ = redactor.RedactBytes(input)
+	copy(input, `{"a":"XXXXXX"}`)
+	if kept[0] != `"secret"` || kept[1] != `secret` {
+		t.Fatalf("values changed with input %q", kept)
+	}
+}
+
 func TestConcurrent(t *testing.T) {
 	waitGroup := sync.WaitGroup{}
 	redactor := NewRedactor([]string{`*.name`}, handler)
@@ -307,6 +953,23 @@ func Benchmark(b *testing.B) {
 			_ = redactor.Redact(bigJson)
 		}
 	})
+	b.Run("bigJson/bytes no match", func(b *testing.B) {
+		redactor := NewRedactor([]string{"age1", "fav1.movie", "1friends", "1name.last"}, func(s string) string { return `REDACTED` })
+		input := []byte(bigJson)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = redactor.RedactBytes(input)
+		}
+	})
+	b.Run("bigJson/append match", func(b *testing.B) {
+		redactor := NewRedactor([]string{"0.name", "1.city", "2.age"}, func(s string) string { return `REDACTED` })
+		input := []byte(bigJson)
+		dst := make([]byte, 0, len(input))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			dst = redactor.AppendRedact(dst[:0], input)
+		}
+	})
 	b.Run("bigJson/match", func(b *testing.B) {
 		redactor := NewRedactor([]string{"0.name", "1.city", "2.age"}, func(s string) string { return `REDACTED` })
 		b.ResetTimer()
@@ -6,16 +6,93 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type node struct {
 	states     []*state
 	isTerminal bool
+	soft       bool                // some terminal states belong to rules with detectors
+	guarded    bool                // some states need resolve against the value before use
+	rule       int                 // rule of the terminal state with the lowest rule, if isTerminal
+	normalize  func(string) string // applied to keys before matching, see NormalizeKeys
 }
 
 type state struct {
 	isTerminal  bool
+	soft        bool   // terminal state of a rule with a detector, it matches string values the detector finds data in
+	rule        int    // index of the expression the terminal state belongs to
+	recursive   bool   // '*': stays in this state on any input
+	wildcard    *state // '#': next state on any input
 	transitions map[string]*state
+	folded      map[string]*state // case-insensitive transitions by folded keys
+	matchers    []matcher         // globs and regexes, tried in order
+	indexes     []indexMatcher    // array index selectors, e.g. [0:3]
+	needsLength bool              // an index selector counts from the end of arrays
+	guards      []*predicate      // the state is valid only at values passing all of them
+}
+
+// indexMatcher is a transition on array indexes selected by any of ranges.
+type indexMatcher struct {
+	ranges []indexRange
+	next   *state
+}
+
+// indexRange selects indexes from from to to exclusive, negative bounds count from the end of the array.
+type indexRange struct {
+	from, to int
+	toEnd    bool // to is the end of the array
+}
+
+func (r indexRange) contains(index, length int) bool {
+	from, to := r.from, r.to
+	if from < 0 {
+		from += length
+	}
+	if r.toEnd {
+		return from <= index
+	}
+	if to < 0 {
+		to += length
+	}
+	return from <= index && index < to
+}
+
+func (r indexRange) needsLength() bool {
+	return r.from < 0 || !r.toEnd && r.to < 0
+}
+
+// matcher is a transition on keys matching a glob or a regex.
+type matcher struct {
+	pattern string   // the segment, for debugging
+	glob    []string // literal parts between '*' of a glob
+	re      *regexp.Regexp
+	next    *state
+}
+
+func (m matcher) match(key string) bool {
+	if m.re != nil {
+		return m.re.MatchString(key)
+	}
+	return matchGlob(m.glob, key)
+}
+
+// matchGlob reports whether key consists of parts in order with anything between them.
+func matchGlob(parts []string, key string) bool {
+	first, last := parts[0], parts[len(parts)-1]
+	if len(key) < len(first)+len(last) || !strings.HasPrefix(key, first) || !strings.HasSuffix(key, last) {
+		return false
+	}
+	key = key[len(first) : len(key)-len(last)]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(key, part)
+		if i < 0 {
+			return false
+		}
+		key = key[i+len(part):]
+	}
+	return true
 }
 
 func newNode() node {
@@ -23,9 +100,11 @@ func newNode() node {
 }
 
 func newState() *state {
-	return &state{transitions: map[string]*state{}}
+	return &state{transitions: map[string]*state{}, folded: map[string]*state{}}
 }
 
+// newNDFA compiles expressions skipping malformed ones, expressions which don't parse are tried as literal keys,
+// see expression.parseLiteral.
 func newNDFA(expressions ...string) node {
 	if len(expressions) == 0 {
 		return newNode()
@@ -33,62 +112,268 @@ func newNDFA(expressions ...string) node {
 	states := make([]*state, 0, len(expressions))
 
 	for i := 0; i < len(expressions); i++ {
-		states = append(states, build(expression(expressions[i]).splitByPoint()))
+		segments, err := expression(expressions[i]).parse()
+		if err != nil {
+			segments, err = expression(expressions[i]).parseLiteral()
+		}
+		if err != nil {
+			continue
+		}
+		states = append(states, build(segments, &state{isTerminal: true, rule: i}, nil))
 	}
 
-	return node{states: states}
+	return node{states: states, guarded: anyGuarded(states)}
+}
+
+// compileNDFA compiles expressions failing on the first malformed one.
+func compileNDFA(opts options, expressions ...string) (node, error) {
+	return compileRules(opts, expressions, nil)
+}
+
+// compileRules is compileNDFA where expressions of rules marked soft match values at and under them,
+// an empty soft expression matches every value.
+func compileRules(opts options, expressions []string, soft []bool) (node, error) {
+	if len(expressions) == 0 {
+		return newNode(), nil
+	}
+	states := make([]*state, 0, len(expressions))
+
+	for i := 0; i < len(expressions); i++ {
+		end := &state{isTerminal: true, rule: i}
+		if soft != nil && soft[i] {
+			end.soft, end.recursive = true, true
+			if expressions[i] == "" {
+				states = append(states, end)
+				continue
+			}
+		}
+		segments, err := expression(expressions[i]).parse()
+		if err == nil {
+			segments, err = opts.apply(expression(expressions[i]), segments)
+		}
+		if err != nil {
+			exprErr := err.(*ExpressionError)
+			exprErr.Index = i
+			return node{}, exprErr
+		}
+		states = append(states, build(segments, end, nil))
+	}
+
+	return node{states: states, guarded: anyGuarded(states), normalize: opts.normalize}, nil
+}
+
+// apply makes segments of e case-insensitive and normalizes their keys.
+// Regexes are not normalized as normalizing their source may change what they match.
+func (o options) apply(e expression, segments []segment) ([]segment, error) {
+	for i := range segments {
+		if o.caseInsensitive && !segments[i].fold {
+			folded, err := segments[i].folded()
+			if err != nil {
+				return nil, &ExpressionError{Expression: string(e), Column: segments[i].column, Reason: "invalid regex: " + err.Error()}
+			}
+			segments[i] = folded
+		}
+		if o.normalize == nil || segments[i].kind != keySegment && segments[i].kind != globSegment {
+			continue
+		}
+		segments[i].key = o.normalize(segments[i].key)
+		for j := range segments[i].glob {
+			segments[i].glob[j] = o.normalize(segments[i].glob[j])
+		}
+	}
+	return segments, nil
 }
 
 func (n node) next(input string, buf []*state) node {
+	if n.normalize != nil {
+		input = n.normalize(input)
+	}
+	return n.step(input, -1, 0, buf)
+}
+
+// nextIndex is next for an element of an array, length is needed only if needsLength reports so.
+func (n node) nextIndex(index, length int, buf []*state) node {
+	return n.step(strconv.Itoa(index), index, length, buf)
+}
+
+// needsLength reports whether matching elements of an array needs its length.
+func (n node) needsLength() bool {
+	for _, s := range n.states {
+		if s.needsLength {
+			return true
+		}
+	}
+	return false
+}
+
+// step moves to the next states by a key or, if index isn't negative, by an array element.
+func (n node) step(input string, index, length int, buf []*state) node {
 	buf = buf[:0]
 	var isTerminal bool
+	var rule int
 	for _, s := range n.states {
-		nextState, nextState2 := s.next(input)
-		if nextState != nil {
-			buf = append(buf, nextState)
-			if nextState.isTerminal {
-				isTerminal = true
-			}
-		}
-		if nextState2 != nil {
-			buf = append(buf, nextState2)
-			if nextState2.isTerminal {
-				isTerminal = true
-			}
+		buf = s.appendNext(buf, input, index, length)
+	}
+	var guarded, soft bool
+	for _, s := range buf {
+		switch {
+		case len(s.guards) != 0:
+			guarded = true
+		case s.soft:
+			soft = true
+		case s.isTerminal && (!isTerminal || s.rule < rule):
+			isTerminal = true
+			rule = s.rule
 		}
 	}
-	if n.isTerminal == isTerminal && len(buf) == 1 && len(n.states) == 1 && buf[0] == n.states[0] {
+	if n.isTerminal == isTerminal && n.soft == soft && len(buf) == 1 && len(n.states) == 1 && buf[0] == n.states[0] {
 		return n
 	}
-	return node{states: buf, isTerminal: isTerminal}
+	return node{states: buf, isTerminal: isTerminal, soft: soft, guarded: guarded, rule: rule, normalize: n.normalize}
 }
 
-func (s *state) nextByKey(input string) *state {
-	if s.transitions[input] != nil {
-		return s.transitions[input]
+func (s *state) appendNext(buf []*state, input string, index, length int) []*state {
+	if s.recursive {
+		buf = appendState(buf, s)
+	}
+	if s.wildcard != nil {
+		buf = appendState(buf, s.wildcard)
+	}
+	if next := s.transitions[input]; next != nil {
+		buf = appendState(buf, next)
+	}
+	if len(s.folded) != 0 {
+		var b [64]byte
+		if next := s.folded[string(appendFold(b[:0], input))]; next != nil {
+			buf = appendState(buf, next)
+		}
+	}
+	for _, m := range s.matchers {
+		if m.match(input) {
+			buf = appendState(buf, m.next)
+		}
+	}
+	if index < 0 {
+		return buf
+	}
+	for _, m := range s.indexes {
+		for _, r := range m.ranges {
+			if r.contains(index, length) {
+				buf = appendState(buf, m.next)
+				break
+			}
+		}
 	}
-	if (input == "*" || input == "#") && s.transitions[`\`+input] != nil {
-		return s.transitions[`\`+input]
+	return buf
+}
+
+// appendState appends s unless it is already present, so recursive states don't pile up.
+func appendState(buf []*state, s *state) []*state {
+	for _, b := range buf {
+		if b == s {
+			return buf
+		}
 	}
-	return nil
+	return append(buf, s)
 }
 
-func (s *state) next(input string) (*state, *state) {
-	return s.nextByKey(input), s.transitions["#"]
+// anyGuarded reports whether an expression starts with a filter of the root.
+func anyGuarded(states []*state) bool {
+	for _, s := range states {
+		if len(s.guards) != 0 {
+			return true
+		}
+	}
+	return false
 }
 
-func build(expressions []string) *state {
-	if len(expressions) == 0 {
-		return &state{isTerminal: true}
+// build returns the state matching segments and then becoming end, guards are conditions on the value it is at.
+func build(segments []segment, end *state, guards []*predicate) *state {
+	if len(segments) == 0 {
+		end.guards = guards
+		return end
 	}
+	seg := segments[0]
 	a := newState()
-	if expressions[0] == "*" {
-		a.transitions["#"] = a
-		a.transitions[expressions[1]] = build(expressions[2:])
-		return a
+	switch seg.kind {
+	case filterSegment:
+		return build(segments[1:], end, append(guards, seg.guards...))
+	case recursiveSegment:
+		next := build(segments[2:], end, segments[1].guards)
+		a.recursive = true
+		a.link(segments[1], next)
+		if guards == nil {
+			return a
+		}
+		// guards are checked once, not at every depth the recursive state stays at
+		f := newState()
+		f.guards, f.wildcard = guards, a
+		return f.link(segments[1], next)
+	default:
+		a.guards = guards
+		return a.link(seg, build(segments[1:], end, seg.guards))
+	}
+}
+
+func (s *state) link(seg segment, next *state) *state {
+	switch seg.kind {
+	case anySegment:
+		s.wildcard = next
+	case globSegment:
+		m := matcher{pattern: seg.key, glob: seg.glob, next: next}
+		if seg.fold {
+			m.pattern, m.re = "(?i)"+seg.key, globRegex(seg.glob)
+		}
+		s.matchers = append(s.matchers, m)
+	case regexSegment:
+		m := matcher{pattern: "/" + seg.key + "/", re: seg.re, next: next}
+		if seg.fold {
+			// seg.re is already case-insensitive, see segment.folded
+			m.pattern = "(?i)" + m.pattern
+		}
+		s.matchers = append(s.matchers, m)
+	case indexSegment:
+		s.indexes = append(s.indexes, indexMatcher{ranges: seg.indexes, next: next})
+		for _, r := range seg.indexes {
+			s.needsLength = s.needsLength || r.needsLength()
+		}
+	default:
+		if seg.fold {
+			s.folded[string(appendFold(nil, seg.key))] = next
+		} else {
+			s.transitions[seg.key] = next
+		}
 	}
-	a.transitions[expressions[0]] = build(expressions[1:])
-	return a
+	return s
+}
+
+// globRegex returns a case-insensitive regex matching like the glob.
+func globRegex(glob []string) *regexp.Regexp {
+	quoted := make([]string, len(glob))
+	for i, part := range glob {
+		quoted[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("(?is)^" + strings.Join(quoted, ".*") + "$")
+}
+
+// appendFold appends key with every rune replaced by the smallest rune of its case folding orbit,
+// so keys equal by strings.EqualFold become equal.
+func appendFold(dst []byte, key string) []byte {
+	for _, r := range key {
+		if r < utf8.RuneSelf {
+			if 'a' <= r && r <= 'z' {
+				r -= 'a' - 'A'
+			}
+			dst = append(dst, byte(r))
+			continue
+		}
+		folded := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			folded = min(folded, f)
+		}
+		dst = utf8.AppendRune(dst, folded)
+	}
+	return dst
 }
 
 func (s *state) string(been map[*state]bool) string {
@@ -101,6 +386,16 @@ func (s *state) string(been map[*state]bool) string {
 		return ""
 	}
 	buffer.WriteString(fmt.Sprintf("state(%p) ", s))
+	if s.recursive {
+		buffer.WriteString(fmt.Sprintf("* -> %p ", s))
+	}
+	if s.wildcard != nil {
+		if s.wildcard.isTerminal {
+			buffer.WriteString("# -> terminal ")
+		} else {
+			buffer.WriteString(fmt.Sprintf("# -> %p ", s.wildcard))
+		}
+	}
 	for k, v := range s.transitions {
 		if v.isTerminal {
 			buffer.WriteString(fmt.Sprintf("%s -> terminal ", k))
@@ -108,10 +403,43 @@ func (s *state) string(been map[*state]bool) string {
 		}
 		buffer.WriteString(fmt.Sprintf("%s -> %p ", k, v))
 	}
+	for _, m := range s.indexes {
+		if m.next.isTerminal {
+			buffer.WriteString(fmt.Sprintf("%v -> terminal ", m.ranges))
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("%v -> %p ", m.ranges, m.next))
+	}
+	for k, v := range s.folded {
+		if v.isTerminal {
+			buffer.WriteString(fmt.Sprintf("(?i)%s -> terminal ", k))
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("(?i)%s -> %p ", k, v))
+	}
+	for _, m := range s.matchers {
+		if m.next.isTerminal {
+			buffer.WriteString(fmt.Sprintf("%s -> terminal ", m.pattern))
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("%s -> %p ", m.pattern, m.next))
+	}
 	buffer.WriteByte('\n')
+	if s.wildcard != nil {
+		buffer.WriteString(s.wildcard.string(been))
+	}
 	for _, v := range s.transitions {
 		buffer.WriteString(v.string(been))
 	}
+	for _, v := range s.folded {
+		buffer.WriteString(v.string(been))
+	}
+	for _, m := range s.indexes {
+		buffer.WriteString(m.next.string(been))
+	}
+	for _, m := range s.matchers {
+		buffer.WriteString(m.next.string(been))
+	}
 	return buffer.String()
 }
 
@@ -211,6 +211,32 @@ func Test_newNDFA(t *testing.T) {
 	}
 }
 
+func Test_matchGlob(t *testing.T) {
+	tests := []struct {
+		glob        []string
+		accepted    []string
+		notAccepted []string
+	}{
+		{glob: []string{"", ""}, accepted: []string{"", "a", "abc"}},
+		{glob: []string{"pass", ""}, accepted: []string{"pass", "password"}, notAccepted: []string{"pas", "userpass"}},
+		{glob: []string{"", "pass", ""}, accepted: []string{"pass", "userpassword", "xpass"}, notAccepted: []string{"pas", "Pass"}},
+		{glob: []string{"ab", "ba"}, accepted: []string{"abba", "ab_ba"}, notAccepted: []string{"aba", "ab"}},
+		{glob: []string{"a", "b", "b", "a"}, accepted: []string{"abba", "a_b_b_a"}, notAccepted: []string{"aba", "abab"}},
+	}
+	for _, tt := range tests {
+		for _, key := range tt.accepted {
+			if !matchGlob(tt.glob, key) {
+				t.Fatalf("glob=%q key=%s", tt.glob, key)
+			}
+		}
+		for _, key := range tt.notAccepted {
+			if matchGlob(tt.glob, key) {
+				t.Fatalf("glob=%q key=%s", tt.glob, key)
+			}
+		}
+	}
+}
+
 func accepts(a node, input string) bool {
 	for _, v := range input {
 		a = a.next(string(v), nil)
@@ -0,0 +1,5 @@
+//go:build !race
+
+package jsonredact
+
+const raceEnabled = false
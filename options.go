@@ -0,0 +1,34 @@
+package jsonredact
+
+// Option configures matching of keys of a Redactor.
+type Option func(*options)
+
+type options struct {
+	caseInsensitive bool
+	normalize       func(string) string
+}
+
+// CaseInsensitive makes every segment of expressions match keys regardless of case,
+// like (?i) prefix of a segment does for one segment. Keys are compared by Unicode simple case folding.
+func CaseInsensitive() Option {
+	return func(o *options) {
+		o.caseInsensitive = true
+	}
+}
+
+// NormalizeKeys applies normalize to keys of expressions and keys of JSON before matching them,
+// e.g. norm.NFC.String of golang.org/x/text/unicode/norm makes differently composed keys equal.
+// Regexes are matched against normalized keys as they are.
+func NormalizeKeys(normalize func(string) string) Option {
+	return func(o *options) {
+		o.normalize = normalize
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package jsonredact
+
+// raceEnabled reports whether tests run with the race detector, which drops sync.Pool items of regexp,
+// so matching regexes allocates.
+const raceEnabled = true
@@ -0,0 +1,25 @@
+package jsonredact
+
+// Match is a value replaced by a redactor.
+type Match struct {
+	Path       string // concrete path of the value in expression syntax, e.g. friends.2.name
+	Rule       int    // index of the rule or the expression which matched the value
+	Expression string // expression of the rule
+	Type       Type   // type of the original value
+}
+
+// RedactWithReport is like Redact but also returns the values it replaced in document order,
+// e.g. to prove which fields were removed. Paths are collected during the same walk, the report costs
+// only the strings of paths of matched values.
+func (r Redactor) RedactWithReport(json string) (string, []Match) {
+	if len(r.automata.states) == 0 {
+		return json, nil
+	}
+	var report []Match
+	buffer := &lazyBuffer{originalJson: json, report: &report}
+	r.redact(json, r.automata, buffer, 0)
+	if !buffer.started {
+		return json, nil
+	}
+	return string(buffer.buf), report
+}
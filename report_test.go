@@ -0,0 +1,55 @@
+package jsonredact
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactWithReport(t *testing.T) {
+	redactor, err := NewFromRules([]Rule{
+		{Expression: "friends.#.name", Handler: valueHandler},
+		{Expression: "*.token", Handler: RawHandler(func(Value) string { return `null` })},
+		{Expression: "", Handler: valueHandler, Detector: DetectEmails()},
+		{Expression: "a\\.b", Handler: valueHandler},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := `{"friends":[{"name":"a"},{"name":{"first":"b"}},{"name":null,"token":7}],"mail":"x@y.com","a.b":[1],"n":1}`
+	got, report := redactor.RedactWithReport(json)
+	if got != redactor.Redact(json) {
+		t.Fatal(got)
+	}
+	want := []Match{
+		{Path: "friends.0.name", Rule: 0, Expression: "friends.#.name", Type: TypeString},
+		{Path: "friends.1.name", Rule: 0, Expression: "friends.#.name", Type: TypeObject},
+		{Path: "friends.2.name", Rule: 0, Expression: "friends.#.name", Type: TypeNull},
+		{Path: "friends.2.token", Rule: 1, Expression: "*.token", Type: TypeNumber},
+		{Path: "mail", Rule: 2, Expression: "", Type: TypeString},
+		{Path: "a\\.b", Rule: 3, Expression: "a\\.b", Type: TypeArray},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Fatalf("got %+v", report)
+	}
+
+	if got, report := redactor.RedactWithReport(`{"n":1}`); got != `{"n":1}` || report != nil {
+		t.Fatal(got, report)
+	}
+	legacy := NewRedactor([]string{"a..b", "c"}, func(string) string { return "" })
+	if _, report := legacy.RedactWithReport(`{"c":1}`); !reflect.DeepEqual(report, []Match{{Path: "c", Rule: 1, Expression: "c", Type: TypeNumber}}) {
+		t.Fatalf("got %+v", report)
+	}
+}
+
+func TestRedactWithReportManyMatches(t *testing.T) {
+	redactor, err := New([]string{"items.#.name"}, valueHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := `{"items":[` + strings.Repeat(`{"name":"a","n":1},`, 19999) + `{"name":"a","n":1}]}`
+	_, report := redactor.RedactWithReport(json)
+	if len(report) != 20000 || report[19999].Path != "items.19999.name" {
+		t.Fatalf("got %d matches", len(report))
+	}
+}
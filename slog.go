@@ -0,0 +1,180 @@
+package jsonredact
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// SlogHandler redacts attributes of log records before passing them to the inner handler,
+// so it works with any output format. Attribute keys are matched by expressions, groups act as objects:
+// `http.request.headers.authorization` matches attribute authorization in group http.request.headers.
+// Maps, structs and slices are matched by their JSON and replaced by the redacted JSON.
+// Filters and predicates of groups opened by WithGroup are not checked, as attributes of such groups come
+// later and in parts, they are assumed to hold: http[method=="POST"].body redacts every body attribute
+// under logger.WithGroup("http"). Groups passed as slog.Group attributes are checked.
+type SlogHandler struct {
+	inner    slog.Handler
+	r        Redactor
+	automata node     // automata after groups of WithGroup
+	groups   []string // groups of WithGroup
+	terminal bool     // a group of WithGroup matched, every attribute is replaced by the handler of rule
+	rule     int
+}
+
+// NewSlogHandler returns a slog.Handler redacting attributes with r and passing records to inner.
+func NewSlogHandler(inner slog.Handler, r Redactor) *SlogHandler {
+	return &SlogHandler{inner: inner, r: r, automata: r.automata}
+}
+
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if !h.terminal && len(h.automata.states) == 0 {
+		return h.inner.Handle(ctx, record)
+	}
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(a, h.automata, h.groups))
+		return true
+	})
+	return h.inner.Handle(ctx, redacted)
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, h.redactAttr(a, h.automata, h.groups))
+	}
+	clone := *h
+	clone.inner = h.inner.WithAttrs(redacted)
+	return &clone
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.inner = h.inner.WithGroup(name)
+	clone.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	if !h.terminal {
+		clone.automata = h.automata.next(name, nil)
+		if clone.automata.guarded {
+			// attributes of the group come later, see SlogHandler
+			clone.automata = clone.automata.assumeHeld()
+		}
+		clone.terminal, clone.rule = clone.automata.isTerminal, clone.automata.rule
+	}
+	return &clone
+}
+
+func (h *SlogHandler) redactAttr(a slog.Attr, automata node, path []string) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if h.terminal {
+		a.Value = h.replace(h.r.handlers[h.rule], a.Value, append(path[:len(path):len(path)], a.Key))
+		return a
+	}
+	if a.Value.Kind() == slog.KindGroup && a.Key == "" {
+		// inlined group, its attributes are on the same level
+		return slog.Attr{Value: slog.GroupValue(h.redactAttrs(a.Value.Group(), automata, path)...)}
+	}
+	next := automata.next(a.Key, make([]*state, 0, 16))
+	if next.guarded {
+		next = next.resolve(gjson.Parse(slogValueJSON(a.Value)), next.states)
+	}
+	path = append(path[:len(path):len(path)], a.Key)
+	if next.isTerminal {
+		a.Value = h.replace(h.r.handlers[next.rule], a.Value, path)
+		return a
+	}
+	if next.soft && a.Value.Kind() == slog.KindString {
+		if _, handler, ok := h.r.detect(next, a.Value.String()); ok {
+			a.Value = h.replace(handler, a.Value, path)
+			return a
+		}
+	}
+	if a.Value.Kind() == slog.KindGroup && len(next.states) != 0 {
+		a.Value = slog.GroupValue(h.redactAttrs(a.Value.Group(), next, path)...)
+	}
+	if a.Value.Kind() == slog.KindAny && len(next.states) != 0 {
+		a.Value = h.redactAny(a.Value, next, path)
+	}
+	return a
+}
+
+// redactAny redacts maps, structs and slices, which JSONHandler writes as objects and arrays, by their JSON.
+func (h *SlogHandler) redactAny(value slog.Value, automata node, path []string) slog.Value {
+	raw := slogValueJSON(value)
+	if root := gjson.Parse(raw); !root.IsObject() && !root.IsArray() {
+		return value
+	}
+	buf := lazyBuffer{originalJson: raw, pathPrefix: path}
+	h.r.redact(raw, automata, &buf, 0)
+	if !buf.started {
+		return value
+	}
+	return slog.AnyValue(json.RawMessage(buf.buf))
+}
+
+func (h *SlogHandler) redactAttrs(attrs []slog.Attr, automata node, path []string) []slog.Attr {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, h.redactAttr(a, automata, path))
+	}
+	return redacted
+}
+
+// replace runs handler on JSON of the value and converts the replacement back to slog.Value.
+func (h *SlogHandler) replace(handler Handler, value slog.Value, path []string) slog.Value {
+	raw := slogValueJSON(value)
+	v := handlerValue(handler, gjson.Parse(raw), func() []string { return path })
+	replacement := gjson.ParseBytes(handler.appendReplacement(nil, v))
+	switch replacement.Type {
+	case gjson.String:
+		return slog.StringValue(replacement.Str)
+	case gjson.Number:
+		if i, err := strconv.ParseInt(replacement.Raw, 10, 64); err == nil {
+			return slog.Int64Value(i)
+		}
+		return slog.Float64Value(replacement.Num)
+	case gjson.True, gjson.False:
+		return slog.BoolValue(replacement.Bool())
+	case gjson.JSON:
+		return slog.AnyValue(json.RawMessage(replacement.Raw))
+	}
+	return slog.AnyValue(nil)
+}
+
+// slogValueJSON encodes the value like slog.JSONHandler does.
+func slogValueJSON(value slog.Value) string {
+	var v any
+	switch value.Kind() {
+	case slog.KindGroup:
+		m := map[string]json.RawMessage{}
+		for _, a := range value.Group() {
+			m[a.Key] = json.RawMessage(slogValueJSON(a.Value.Resolve()))
+		}
+		v = m
+	case slog.KindDuration:
+		v = int64(value.Duration())
+	case slog.KindTime:
+		v = value.Time().Format(time.RFC3339Nano)
+	default:
+		v = value.Any()
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(value.String())
+	}
+	return string(b)
+}
@@ -0,0 +1,140 @@
+package jsonredact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	redactor, err := NewFromRules([]Rule{
+		{Expression: "http.request.headers.authorization", Handler: ValueHandler(func(v Value) string { return v.Path })},
+		{Expression: "*.password", Handler: ValueHandler(func(Value) string { return "REDACTED" })},
+		{Expression: "card", Handler: RawHandler(func(v Value) string { return v.Str[len(v.Str)-4:] })},
+		{Expression: "secret", Handler: RawHandler(func(v Value) string { return `{"hidden":true}` })},
+		{Expression: "err", Handler: ValueHandler(func(v Value) string { return v.Type.String() + ":" + v.Str })},
+		{Expression: "token?(len>3)", Handler: ValueHandler(func(Value) string { return "LONG" })},
+		{Expression: `req[method=="POST"].body`, Handler: ValueHandler(func(Value) string { return "REDACTED" })},
+		{Expression: "obj?(type=object)", Handler: ValueHandler(func(Value) string { return "REDACTED" })},
+		{Expression: "user.pin", Handler: ValueHandler(func(v Value) string { return v.Path })},
+		{Expression: "ids.[-1]", Handler: ValueHandler(func(Value) string { return "LAST" })},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		log  func(logger *slog.Logger)
+		json string
+		text string
+	}{
+		{
+			name: "nested groups",
+			log: func(logger *slog.Logger) {
+				logger.Info("m", slog.Group("http", slog.Group("request", slog.Group("headers", "authorization", "Bearer x", "accept", "*/*"))))
+			},
+			json: `{"level":"INFO","msg":"m","http":{"request":{"headers":{"authorization":"http.request.headers.authorization","accept":"*/*"}}}}`,
+			text: `level=INFO msg=m http.request.headers.authorization=http.request.headers.authorization http.request.headers.accept=*/*`,
+		},
+		{
+			name: "predicate",
+			log: func(logger *slog.Logger) {
+				logger.Info("m", "token", "abc", slog.Group("g", "token", "abcd"), "token", "abcd")
+			},
+			json: `{"level":"INFO","msg":"m","token":"abc","g":{"token":"abcd"},"token":"LONG"}`,
+			text: `level=INFO msg=m token=abc g.token=abcd token=LONG`,
+		},
+		{
+			name: "WithGroup",
+			log: func(logger *slog.Logger) {
+				logger.WithGroup("http").WithGroup("request").Info("m", slog.Group("headers", "authorization", "Bearer x"))
+			},
+			json: `{"level":"INFO","msg":"m","http":{"request":{"headers":{"authorization":"http.request.headers.authorization"}}}}`,
+			text: `level=INFO msg=m http.request.headers.authorization=http.request.headers.authorization`,
+		},
+		{
+			name: "WithAttrs and recursive",
+			log: func(logger *slog.Logger) {
+				logger.With("password", "1").WithGroup("user").With("password", "2").Info("m", "name", "bob", "password", "3")
+			},
+			json: `{"level":"INFO","msg":"m","password":"REDACTED","user":{"password":"REDACTED","name":"bob","password":"REDACTED"}}`,
+			text: `level=INFO msg=m password=REDACTED user.password=REDACTED user.name=bob user.password=REDACTED`,
+		},
+		{
+			name: "raw replacement keeps types",
+			log: func(logger *slog.Logger) {
+				logger.Info("m", "card", int64(4111111111111111), "secret", "x", slog.Group("", "password", "4"))
+			},
+			json: `{"level":"INFO","msg":"m","card":1111,"secret":{"hidden":true},"password":"REDACTED"}`,
+			text: `level=INFO msg=m card=1111 secret="{\"hidden\":true}" password=REDACTED`,
+		},
+		{
+			name: "whole group",
+			log: func(logger *slog.Logger) {
+				logger.WithGroup("secret").Info("m", "a", 1, "b", "2")
+			},
+			json: `{"level":"INFO","msg":"m","secret":{"a":{"hidden":true},"b":{"hidden":true}}}`,
+			text: `level=INFO msg=m secret.a="{\"hidden\":true}" secret.b="{\"hidden\":true}"`,
+		},
+		{
+			name: "filter of inline group",
+			log: func(logger *slog.Logger) {
+				logger.Info("m", slog.Group("req", "method", "GET", "body", "a"), slog.Group("req", "method", "POST", "body", "b"))
+			},
+			json: `{"level":"INFO","msg":"m","req":{"method":"GET","body":"a"},"req":{"method":"POST","body":"REDACTED"}}`,
+			text: `level=INFO msg=m req.method=GET req.body=a req.method=POST req.body=REDACTED`,
+		},
+		{
+			name: "guards of WithGroup are assumed to hold",
+			log: func(logger *slog.Logger) {
+				logger.WithGroup("req").Info("m", "method", "GET", "body", "a")
+				logger.WithGroup("obj").Info("m", "a", 1)
+			},
+			json: `{"level":"INFO","msg":"m","req":{"method":"GET","body":"REDACTED"}}` + "\n" +
+				`{"level":"INFO","msg":"m","obj":{"a":"REDACTED"}}`,
+			text: `level=INFO msg=m req.method=GET req.body=REDACTED` + "\n" + `level=INFO msg=m obj.a=REDACTED`,
+		},
+		{
+			name: "map, struct and slice values",
+			log: func(logger *slog.Logger) {
+				type user struct {
+					Name string `json:"name"`
+					Pin  string `json:"pin"`
+				}
+				logger.With("user", map[string]any{"pin": "x"}).Info("m", "user", user{Name: "bob", Pin: "y"}, "ids", []int{1, 2})
+			},
+			json: `{"level":"INFO","msg":"m","user":{"pin":"user.pin"},"user":{"name":"bob","pin":"user.pin"},"ids":[1,"LAST"]}`,
+			text: `level=INFO msg=m user="{\"pin\":\"user.pin\"}" user="{\"name\":\"bob\",\"pin\":\"user.pin\"}" ids="[1,\"LAST\"]"`,
+		},
+		{
+			name: "error value",
+			log: func(logger *slog.Logger) {
+				logger.Info("m", "err", errors.New("boom"))
+			},
+			json: `{"level":"INFO","msg":"m","err":"string:boom"}`,
+			text: `level=INFO msg=m err=string:boom`,
+		},
+	}
+	noTime := func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			tt.log(slog.New(NewSlogHandler(slog.NewJSONHandler(out, &slog.HandlerOptions{ReplaceAttr: noTime}), redactor)))
+			if got := strings.TrimSpace(out.String()); got != tt.json {
+				t.Fatalf("json\ngot  %s\nwant %s", got, tt.json)
+			}
+			out.Reset()
+			tt.log(slog.New(NewSlogHandler(slog.NewTextHandler(out, &slog.HandlerOptions{ReplaceAttr: noTime}), redactor)))
+			if got := strings.TrimSpace(out.String()); got != tt.text {
+				t.Fatalf("text\ngot  %s\nwant %s", got, tt.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package jsonredact
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// RedactStream writes json read from r redacted to w.
+// It runs the same automata as Redact but holds in memory only keys and matched values, not the whole document,
+// for valid JSON the output is the same as of Redact.
+// Filters, predicates and negative indexes need to see a value before matching in it, so the value they apply to
+// is held in memory: a filter of the root, e.g. [type=="x"].token, or a negative index of a root array,
+// e.g. [-1].token, holds the whole document.
+func (r Redactor) RedactStream(w io.Writer, rd io.Reader) error {
+	out := bufio.NewWriter(w)
+	s := &streamRedactor{Redactor: r, in: bufio.NewReader(rd), out: out}
+	err := s.run()
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// streamRedactor mirrors redact over a reader.
+// Until the first match every byte read is written as is, like lazyBuffer does;
+// after it only tokens of walked objects and arrays are written, skipping whitespace.
+type streamRedactor struct {
+	Redactor
+	in       *bufio.Reader
+	out      *bufio.Writer
+	offset   int // bytes read so far
+	started  bool
+	copying  bool       // write bytes read even if started
+	muted    bool       // don't write bytes read
+	capture  bool       // append bytes read to captured
+	keys     []string   // keys of walked values
+	states   [][]*state // buffers for automata steps of every depth
+	captured []byte
+	scratch  []byte
+}
+
+func (s *streamRedactor) run() error {
+	if len(s.automata.states) == 0 {
+		_, err := io.Copy(s.out, s.in)
+		return err
+	}
+	c, err := s.skipSpace()
+	if err != nil {
+		return ignoreEOF(err)
+	}
+	switch {
+	case c != '{' && c != '[':
+	case s.automata.guarded || c == '[' && s.automata.needsLength():
+		err = s.walkBuffered(s.automata)
+	default:
+		err = s.walk(s.automata, 0)
+	}
+	if err != nil {
+		return err
+	}
+	if s.started {
+		return nil // Redact drops anything after the root
+	}
+	_, err = io.Copy(s.out, s.in)
+	return err
+}
+
+func (s *streamRedactor) walk(automata node, depth int) error {
+	open, _ := s.readByte()
+	closing := byte('}')
+	if open == '[' {
+		closing = ']'
+	}
+	s.write(open)
+	if len(s.states) == depth {
+		s.states = append(s.states, make([]*state, 0, 16))
+	}
+	for index := 0; ; index++ {
+		c, err := s.skipSpace()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if c == closing {
+			_, _ = s.readByte()
+			s.write(closing)
+			return nil
+		}
+		if index != 0 {
+			if c != ',' {
+				return s.syntaxError(c)
+			}
+			_, _ = s.readByte()
+			s.write(',')
+			if c, err = s.skipSpace(); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		var key string
+		var next node
+		if open == '[' {
+			key = strconv.Itoa(index)
+			next = automata.nextIndex(index, 0, s.states[depth])
+		} else {
+			if key, err = s.key(c); err != nil {
+				return err
+			}
+			if c, err = s.skipSpace(); err != nil {
+				return unexpectedEOF(err)
+			}
+			next = automata.next(key, s.states[depth])
+		}
+		if next.isTerminal && !next.guarded {
+			if err := s.replace(s.handlers[next.rule], key); err != nil {
+				return err
+			}
+			continue
+		}
+		if next.soft && c == '"' && !next.guarded {
+			if err := s.detect(next, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if next.guarded || len(next.states) != 0 && (c == '{' || c == '[') {
+			s.keys = append(s.keys, key)
+			if next.guarded || c == '[' && next.needsLength() {
+				err = s.walkBuffered(next)
+			} else {
+				err = s.walk(next, depth+1)
+			}
+			if err != nil {
+				return err
+			}
+			s.keys = s.keys[:len(s.keys)-1]
+			continue
+		}
+		s.copying = true
+		err = s.scanValue()
+		s.copying = false
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// key reads an object key and the colon after it, returning the unescaped key.
+func (s *streamRedactor) key(c byte) (string, error) {
+	if c != '"' {
+		return "", s.syntaxError(c)
+	}
+	s.capture, s.captured = true, s.captured[:0]
+	err := s.scanString()
+	s.capture = false
+	if err != nil {
+		return "", err
+	}
+	raw := string(s.captured)
+	s.writeString(raw)
+	if c, err = s.skipSpace(); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if c != ':' {
+		return "", s.syntaxError(c)
+	}
+	_, _ = s.readByte()
+	s.write(':')
+	return gjson.Parse(raw).Str, nil
+}
+
+// replace reads a matched value and writes its replacement.
+func (s *streamRedactor) replace(handler Handler, key string) error {
+	raw, err := s.captureValue()
+	if err != nil {
+		return err
+	}
+	s.writeReplacement(handler, gjson.Parse(raw), func() []string {
+		return append(s.keys[:len(s.keys):len(s.keys)], key)
+	})
+	return nil
+}
+
+// detect reads a string value and writes its replacement if a detector of n finds sensitive data in it.
+func (s *streamRedactor) detect(n node, key string) error {
+	raw, err := s.captureValue()
+	if err != nil {
+		return err
+	}
+	value := gjson.Parse(raw)
+	_, handler, ok := s.Redactor.detect(n, value.Str)
+	if !ok {
+		_, _ = s.out.WriteString(raw)
+		return nil
+	}
+	s.writeReplacement(handler, value, func() []string {
+		return append(s.keys[:len(s.keys):len(s.keys)], key)
+	})
+	return nil
+}
+
+func (s *streamRedactor) writeReplacement(handler Handler, value gjson.Result, path func() []string) {
+	s.started = true
+	s.scratch = handler.appendReplacement(s.scratch[:0], handlerValue(handler, value, path))
+	_, _ = s.out.Write(s.scratch)
+}
+
+// walkBuffered reads the whole value and redacts it in memory like Redact does, for automata which needs
+// to see the value before matching it or its elements, e.g. by predicates or negative indexes.
+func (s *streamRedactor) walkBuffered(automata node) error {
+	raw, err := s.captureValue()
+	if err != nil {
+		return err
+	}
+	value := gjson.Parse(raw)
+	if automata.guarded {
+		automata = automata.resolve(value, nil)
+	}
+	if automata.isTerminal {
+		s.writeReplacement(s.handlers[automata.rule], value, func() []string { return s.keys })
+		return nil
+	}
+	if automata.soft && value.Type == gjson.String {
+		if _, handler, ok := s.Redactor.detect(automata, value.Str); ok {
+			s.writeReplacement(handler, value, func() []string { return s.keys })
+			return nil
+		}
+	}
+	if len(automata.states) == 0 || !value.IsObject() && !value.IsArray() {
+		_, _ = s.out.WriteString(raw)
+		return nil
+	}
+	buf := lazyBuffer{originalJson: raw, pathPrefix: s.keys}
+	if s.started {
+		buf.start(0)
+	}
+	s.redact(raw, automata, &buf, 0)
+	if !buf.started {
+		_, _ = s.out.WriteString(raw)
+		return nil
+	}
+	s.started = true
+	_, _ = s.out.Write(buf.buf)
+	return nil
+}
+
+// captureValue reads a value without writing it.
+func (s *streamRedactor) captureValue() (string, error) {
+	s.capture, s.muted, s.captured = true, true, s.captured[:0]
+	err := s.scanValue()
+	s.capture, s.muted = false, false
+	return string(s.captured), err
+}
+
+func (s *streamRedactor) scanValue() error {
+	c, err := s.peek()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	switch c {
+	case '"':
+		return s.scanString()
+	case '{', '[':
+		return s.scanContainer()
+	case ',', ':', '}', ']':
+		return s.syntaxError(c)
+	}
+	return s.scanLiteral()
+}
+
+// scanString reads a string including quotes.
+func (s *streamRedactor) scanString() error {
+	_, _ = s.readByte()
+	for escaped := false; ; {
+		c, err := s.readByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			return nil
+		}
+	}
+}
+
+// scanContainer reads an object or an array as is.
+func (s *streamRedactor) scanContainer() error {
+	depth, inString, escaped := 0, false, false
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// scanLiteral reads a number, true, false or null.
+func (s *streamRedactor) scanLiteral() error {
+	for {
+		c, err := s.peek()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isSpace(c) || c == ',' || c == '}' || c == ']' || c == ':' {
+			return nil
+		}
+		_, _ = s.readByte()
+	}
+}
+
+// skipSpace reads whitespace and returns the next byte without reading it.
+func (s *streamRedactor) skipSpace() (byte, error) {
+	for {
+		c, err := s.peek()
+		if err != nil || !isSpace(c) {
+			return c, err
+		}
+		_, _ = s.readByte()
+	}
+}
+
+func (s *streamRedactor) peek() (byte, error) {
+	b, err := s.in.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (s *streamRedactor) readByte() (byte, error) {
+	c, err := s.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	s.offset++
+	if s.capture {
+		s.captured = append(s.captured, c)
+	}
+	if !s.muted && (!s.started || s.copying) {
+		_ = s.out.WriteByte(c)
+	}
+	return c, nil
+}
+
+// write writes a token of a walked value, before the first match tokens are written by readByte.
+func (s *streamRedactor) write(c byte) {
+	if s.started {
+		_ = s.out.WriteByte(c)
+	}
+}
+
+func (s *streamRedactor) writeString(str string) {
+	if s.started {
+		_, _ = s.out.WriteString(str)
+	}
+}
+
+func (s *streamRedactor) syntaxError(c byte) error {
+	return fmt.Errorf("jsonredact: invalid character %q at offset %d", c, s.offset)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
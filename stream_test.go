@@ -0,0 +1,145 @@
+package jsonredact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRedactStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		expressions []string
+	}{
+		{name: "no match", json: bigJson, expressions: []string{"1age", "1friends"}},
+		{name: "match", json: bigJson, expressions: []string{"0.name", "1.city", "2.age"}},
+		{name: "recursive", json: bigJson, expressions: []string{"*.name", "*.hobbies.1"}},
+		{name: "deep", json: deepJson, expressions: []string{"b.a.b.b.a.b"}},
+		{name: "whitespace", json: " \n{ \"a\" : { \"b\" : [ 1 , 2 ] } , \"c\" : 3 , \"d\" : [ { \"e\" : 4 } ] }\n ", expressions: []string{"a.b.1", "d.#.e"}},
+		{name: "whitespace no match", json: " \n{ \"a\" : { \"b\" : [ 1 , 2 ] } }\n ", expressions: []string{"x"}},
+		{name: "escaped keys", json: `{"a\"b":{"c\\d":"x\"y"},"a":1}`, expressions: []string{`a"b.c\\d`, "a"}},
+		{name: "empty containers", json: `{"a":{},"b":[],"c":{"d":[ ]}, "e":1}`, expressions: []string{"c.d.#", "e"}},
+		{name: "root array", json: `[ {"a":1}, [2, {"a":[3]}], "a" ]`, expressions: []string{"*.a"}},
+		{name: "non json", json: "ynbtrvcew98hguibrfd", expressions: []string{"#"}},
+		{name: "scalar root", json: ` "abc" `, expressions: []string{"#"}},
+		{name: "empty", json: "", expressions: []string{"#"}},
+		{name: "no expressions", json: `{"a":1}`},
+		{name: "glob and regex", json: bigJson, expressions: []string{"*.*ame", "#./^c.ty$/"}},
+		{name: "index ranges", json: bigJson, expressions: []string{"[1:].friends.[0]", "#.hobbies.[-1]"}},
+		{name: "negative index in root", json: ` [ {"a": [1, 2]}, 2, 3 ] `, expressions: []string{"[-2]", "[0].a.[-1]"}},
+		{name: "negative index no match", json: ` [ {"a": [1, 2]}, 2, 3 ] `, expressions: []string{"[-1].a"}},
+		{name: "negative index after match", json: `{"x": 1, "a": [ 1, 2 , 3 ]}`, expressions: []string{"x", "a.[-1]"}},
+		{name: "predicates", json: bigJson, expressions: []string{"*.name?(len>4)", "#.friends?(len>=2)", "#.age?(regex=^2)"}},
+		{name: "predicate after match", json: `{"x": 1, "a": { "b" : "long"}, "c": "s"}`, expressions: []string{"x", "a?(type=string)", "a.b?(len>3)", "c?(len>3)"}},
+		{name: "filters", json: bigJson, expressions: []string{`#[city=="Austin"].name`, `[0.age==78].#.friends.[0][name=="Mateo"].hobbies`}},
+		{name: "root filter", json: ` {"t": "x", "a": {"b": 1}} `, expressions: []string{`[t=="x"].a.b`}},
+		{name: "root filter no match", json: ` {"t": "y", "a": {"b": 1}} `, expressions: []string{`[t=="x"].a.b`}},
+		{name: "trailing data no match", json: `{"a":1} {"a":2}`, expressions: []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor := NewRedactor(tt.expressions, handler)
+			assertStreamEqualsRedact(t, redactor, tt.json)
+		})
+	}
+}
+
+func TestRedactStreamRandom(t *testing.T) {
+	for i := 0; i < 300; i++ {
+		doc := generateSpacedJSON(3)
+		expressions := generateExpressions()
+		redactor, err := New(expressions, ValueHandler(func(v Value) string { return v.Path + ":" + v.Type.String() }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertStreamEqualsRedact(t, redactor, doc)
+	}
+}
+
+func TestRedactStreamSyntaxError(t *testing.T) {
+	redactor := NewRedactor([]string{"a.b"}, handler)
+	for _, input := range []string{`{"a":{"b":1`, `{"a" 1}`, `{"a":{"b":"x`, `{"a":[1 2]}`, `{a:1}`} {
+		err := redactor.RedactStream(io.Discard, strings.NewReader(input))
+		if err == nil {
+			t.Fatalf("want error for %s", input)
+		}
+	}
+	err := redactor.RedactStream(io.Discard, strings.NewReader(`{"a":{"b":1`))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatal(err)
+	}
+}
+
+func TestRedactStreamBufferedPath(t *testing.T) {
+	redactor, err := New([]string{"a.b.[-1]"}, ValueHandler(func(v Value) string { return v.Path }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := `{"a": {"b": [1, 2]}}`
+	if got := redactor.Redact(json); got != `{"a": {"b": [1, "a.b.1"]}}` {
+		t.Fatal(got)
+	}
+	assertStreamEqualsRedact(t, redactor, json)
+}
+
+func assertStreamEqualsRedact(t *testing.T, redactor Redactor, doc string) {
+	t.Helper()
+	want := redactor.Redact(doc)
+	for _, reader := range []io.Reader{strings.NewReader(doc), iotest.OneByteReader(strings.NewReader(doc))} {
+		out := bytes.Buffer{}
+		if err := redactor.RedactStream(&out, reader); err != nil {
+			t.Fatalf("%s: %v", doc, err)
+		}
+		if out.String() != want {
+			t.Fatalf("input   %s\nstream  %s\nredact  %s", doc, out.String(), want)
+		}
+	}
+}
+
+// generateSpacedJSON generates valid json of keys "abcd" with random whitespace between tokens.
+func generateSpacedJSON(depth int) string {
+	space := func() string { return []string{"", "", " ", "\n", " \t "}[random.Intn(5)] }
+	var value func(depth int) string
+	value = func(depth int) string {
+		switch n := random.Intn(8); {
+		case depth == 0 || n < 3:
+			scalars := []string{`1`, `-2.5e3`, `"s"`, `"q\"\\"`, `true`, `null`, `""`}
+			return scalars[random.Intn(len(scalars))]
+		case n < 6:
+			builder := strings.Builder{}
+			builder.WriteString("{" + space())
+			for i := range random.Intn(4) {
+				if i != 0 {
+					builder.WriteString(space() + "," + space())
+				}
+				builder.WriteString(strconv.Quote(string(rune('a'+random.Intn(4)))) + space() + ":" + space() + value(depth-1))
+			}
+			builder.WriteString(space() + "}")
+			return builder.String()
+		default:
+			builder := strings.Builder{}
+			builder.WriteString("[" + space())
+			for i := range random.Intn(4) {
+				if i != 0 {
+					builder.WriteString(space() + "," + space())
+				}
+				builder.WriteString(value(depth - 1))
+			}
+			builder.WriteString(space() + "]")
+			return builder.String()
+		}
+	}
+	var doc string
+	for {
+		doc = space() + value(depth) + space()
+		if json.Valid([]byte(doc)) && strings.ContainsAny(strings.TrimSpace(doc)[:1], "{[") {
+			return doc
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package jsonredact
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Writer redacts JSON records, one per line, before writing them to the underlying writer.
+// Put it in front of a JSON logger output to redact its records. It is safe for concurrent use.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	r       Redactor
+	pending []byte // incomplete record
+	buf     []byte
+}
+
+// NewWriter returns a Writer redacting records with r and writing them to w.
+// Every record is written to w by a single Write call.
+func NewWriter(w io.Writer, r Redactor) *Writer {
+	return &Writer{w: w, r: r}
+}
+
+// Write buffers p until a record is complete, then redacts and writes it.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for {
+		i := bytes.IndexByte(p[n:], '\n')
+		if i < 0 {
+			break
+		}
+		line := p[n : n+i+1]
+		if len(w.pending) != 0 {
+			w.pending = append(w.pending, line...)
+			line = w.pending
+		}
+		w.buf = w.r.appendRedactLine(w.buf[:0], line)
+		w.pending = w.pending[:0]
+		if _, err := w.w.Write(w.buf); err != nil {
+			return n, err
+		}
+		n += i + 1
+	}
+	w.pending = append(w.pending, p[n:]...)
+	return len(p), nil
+}
+
+// Flush redacts and writes the incomplete record if any.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	w.buf = w.r.appendRedactLine(w.buf[:0], w.pending)
+	w.pending = w.pending[:0]
+	_, err := w.w.Write(w.buf)
+	return err
+}
@@ -0,0 +1,79 @@
+package jsonredact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	out := &recordingWriter{}
+	w := NewWriter(out, NewRedactor([]string{"password"}, handler))
+	for _, chunk := range []string{
+		`{"user":"a","password":"1"}` + "\n",
+		`{"user":"b",`, `"password":"2"}` + "\n" + `{"pass`, `word":"3"}` + "\n",
+		"plain text\n",
+		`{"password":"4"}`,
+	} {
+		n, err := w.Write([]byte(chunk))
+		if err != nil || n != len(chunk) {
+			t.Fatal(n, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"user":"a","password":"REDACTED"}` + "\n",
+		`{"user":"b","password":"REDACTED"}` + "\n",
+		`{"password":"REDACTED"}` + "\n",
+		"plain text\n",
+		`{"password":"REDACTED"}`,
+	}
+	if strings.Join(out.writes, "|") != strings.Join(want, "|") {
+		t.Fatalf("%q", out.writes)
+	}
+}
+
+func TestWriterSlogJSONHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(NewWriter(out, NewRedactor([]string{"*.token"}, handler)), nil))
+	wg := sync.WaitGroup{}
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info("login", "user", "bob", slog.Group("auth", "token", "secret"))
+		}()
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 100 {
+		t.Fatal(len(lines))
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "secret") || !strings.Contains(line, `"auth":{"token":"REDACTED"}`) {
+			t.Fatal(line)
+		}
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	w := NewWriter(errWriter{}, NewRedactor([]string{"a"}, handler))
+	n, err := w.Write([]byte("{\"a\":1}\n{\"a\":2}\n"))
+	if n != 0 || !errors.Is(err, errWrite) {
+		t.Fatal(n, err)
+	}
+}
+
+type recordingWriter struct {
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}